@@ -3,60 +3,115 @@ package cache
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/zly-app/component/redis"
 
 	"github.com/zly-app/cache/v2/cachedb/bigcache"
+	"github.com/zly-app/cache/v2/cachedb/fallback"
 	"github.com/zly-app/cache/v2/cachedb/freecache"
+	"github.com/zly-app/cache/v2/cachedb/no_cache"
 	"github.com/zly-app/cache/v2/cachedb/redis_cache"
 	"github.com/zly-app/cache/v2/core"
+	"github.com/zly-app/cache/v2/errs"
 	"github.com/zly-app/cache/v2/single_flight"
 )
 
 type Cache struct {
 	cacheName        string
 	cacheDB          core.ICacheDB
+	backend          string // 缓存数据库类型
 	compactor        core.ICompactor
+	compactorName    string
 	serializer       core.ISerializer
+	serializerName   string
 	sf               core.ISingleFlight // 单跑模块
-	expireSec        int                // 默认过期时间
+	expire           time.Duration      // 默认有效期, 为0表示永不过期
 	ignoreCacheFault bool               // 是否忽略缓存数据库故障
+	metrics          *cacheMetrics      // 指标上报
+	traceHashKey     bool               // 链路追踪中是否记录key的hash值
+	listener         *listenerHub       // 事件分发器
+	breaker          *circuitBreaker    // 缓存数据库熔断器
+	getTimeout       time.Duration      // 缓存数据库Get超时
+	setTimeout       time.Duration      // 缓存数据库Set超时
+	delTimeout       time.Duration      // 缓存数据库Del超时
+	hedge            *hedger            // 对冲读
+	retry            *retryPolicy       // 缓存数据库重试策略
+	loadLimiter      *loadLimiter       // 加载限制器
+	loadQueueTimeout time.Duration      // 加载名额的排队超时
+	loadErrCache     *loadErrCache      // 加载错误缓存
+	writeBehind      *writeBehind       // 延迟写入
+	delayDel         *delayDeleter      // 延迟删除
+
+	staleWriteProtect bool      // 是否启用旧数据写入保护
+	leases            *leaseMap // 本地版本表, 缓存数据库不支持版本控制时使用
+	tagIndex          *tagIndex // 本地标签索引, 缓存数据库不支持标签时使用
+	enableDependency  bool      // 是否启用依赖追踪
+	keyPrefix         string    // 传给缓存数据库的key的前缀, 包括版本
+
+	slidingExpire bool          // 是否默认启用滑动过期
+	maxLifetime   time.Duration // 数据的最大存活时间, 为0表示不限制
+	touchable     bool          // 缓存数据库是否支持修改有效期
+
+	decodeErrorAsMiss bool        // 解码失败视为未命中
+	decodeErrLog      *logLimiter // 解码失败日志限流
+
+	schemaVersion   uint16                // 当前的数据结构版本
+	upcasters       map[int]core.Upcaster // 数据升级函数, key为升级前的版本
+	upcastWriteBack bool                  // 是否将升级后的数据写回缓存
 }
 
 func (c *Cache) Close() error {
-	return c.cacheDB.Close()
+	if c.writeBehind != nil {
+		c.writeBehind.Close()
+	}
+	c.delayDel.Close()
+	err := c.cacheDB.Close()
+	c.listener.Close()
+	return err
 }
 
-func (c *Cache) marshalQuery(data interface{}, serializer core.ISerializer, compactor core.ICompactor) ([]byte, error) {
+func (c *Cache) marshalQuery(key string, data interface{}, serializer core.ISerializer, compactor core.ICompactor) ([]byte, error) {
 	if data == nil {
 		return nil, nil
 	}
 
 	rawData, err := serializer.MarshalBytes(data)
 	if err != nil {
-		return nil, fmt.Errorf("序列化失败: %v", err)
+		return nil, &CodecError{Key: key, Stage: errs.StageSerialize, Err: err}
 	}
 
 	comData, err := compactor.CompressBytes(rawData)
 	if err != nil {
-		return nil, fmt.Errorf("压缩失败: %v", err)
+		return nil, &CodecError{Key: key, Stage: errs.StageCompress, Err: err}
 	}
 	return comData, nil
 }
 
-func (c *Cache) unmarshalQuery(comData []byte, aPtr interface{}, serializer core.ISerializer, compactor core.ICompactor) error {
-	if len(comData) == 0 {
+// 解码缓存数据, 数据信封中记录了编解码器时使用信封中的编解码器
+func (c *Cache) unmarshalQuery(key string, bs []byte, aPtr interface{}, serializer core.ISerializer, compactor core.ICompactor) error {
+	v, err := parseValue(key, bs)
+	if err != nil {
+		return err
+	}
+	return c.decodeValue(key, v, aPtr, serializer, compactor)
+}
+
+// 解码已解析的缓存数据
+func (c *Cache) decodeValue(key string, v *cacheValue, aPtr interface{}, serializer core.ISerializer, compactor core.ICompactor) error {
+	if len(v.payload) == 0 || (v.env != nil && v.env.Flags&envelopeFlagNil != 0) {
 		return ErrDataIsNil
 	}
+	serializer, compactor = v.env.codec(serializer, compactor)
 
-	rawData, err := compactor.UnCompressBytes(comData)
+	rawData, err := compactor.UnCompressBytes(v.payload)
 	if err != nil {
-		return fmt.Errorf("解压缩失败: %v", err)
+		return &CodecError{Key: key, Stage: errs.StageUncompress, Err: err}
 	}
 
 	err = serializer.UnmarshalBytes(rawData, aPtr)
 	if err != nil {
-		return fmt.Errorf("反序列化失败: %v", err)
+		return &CodecError{Key: key, Stage: errs.StageDeserialize, Err: err}
 	}
 	return nil
 }
@@ -64,18 +119,109 @@ func (c *Cache) unmarshalQuery(comData []byte, aPtr interface{}, serializer core
 func NewCache(name string, conf *Config) (ICache, error) {
 	err := conf.Check()
 	if err != nil {
-		return nil, fmt.Errorf("cache配置检查失败: %v", err)
+		return nil, fmt.Errorf("cache配置检查失败: %w", err)
 	}
 
 	cache := &Cache{
 		cacheName:        name,
-		expireSec:        conf.ExpireSec,
+		backend:          strings.ToLower(conf.CacheDB.Type),
+		expire:           time.Duration(conf.ExpireSec) * time.Second,
 		ignoreCacheFault: conf.IgnoreCacheFault,
+		traceHashKey:     conf.TraceHashKey,
+		keyPrefix:        makeKeyPrefix(conf.KeyPrefix, conf.Version),
+		slidingExpire:    conf.SlidingExpire.Enable,
+		maxLifetime:      time.Duration(conf.SlidingExpire.MaxLifetimeSec) * time.Second,
+		getTimeout:       time.Duration(conf.Timeout.GetMs) * time.Millisecond,
+		setTimeout:       time.Duration(conf.Timeout.SetMs) * time.Millisecond,
+		delTimeout:       time.Duration(conf.Timeout.DelMs) * time.Millisecond,
+		hedge:            newHedger(conf),
+		retry:            newRetryPolicy(conf),
+		loadLimiter:      newLoadLimiter(conf),
+		loadQueueTimeout: time.Duration(conf.Loader.QueueTimeoutMs) * time.Millisecond,
+		loadErrCache:     newLoadErrCache(conf),
+	}
+
+	cache.cacheDB, err = newCacheDB(cache.backend, conf)
+	if err != nil {
+		return nil, err
+	}
+	if fallbackType := strings.ToLower(conf.CacheDB.Fallback.Type); fallbackType != "" {
+		cache.cacheDB = fallback.NewCache(cache.cacheDB, func() (core.ICacheDB, error) {
+			return newCacheDB(fallbackType, conf)
+		}, conf.CacheDB.Fallback.CheckIntervalSec, conf.CacheDB.Fallback.FailThreshold)
+	}
+
+	_, cache.touchable = unwrapCacheDB(cache.cacheDB).(core.IExpireCacheDB)
+
+	cache.compactorName = strings.ToLower(conf.Compactor)
+	cache.compactor = GetCompactor(cache.compactorName)
+	cache.serializerName = strings.ToLower(conf.Serializer)
+	cache.serializer = GetSerializer(cache.serializerName)
+	cache.sf = single_flight.GetSingleFlight(strings.ToLower(conf.SingleFlight))
+	cache.metrics = newCacheMetrics(name, cache.backend, conf.EnableMetrics)
+	cache.breaker = newCircuitBreaker(name, conf, cache.metrics.SetBreakerState)
+	cache.listener = newListenerHub(name, conf.Listeners, conf.ListenerAsync, conf.ListenerQueueSize)
+	primary := unwrapCacheDB(cache.cacheDB)
+	if conf.StaleWriteProtect {
+		cache.staleWriteProtect = true
+		if _, ok := primary.(core.IVersionedCacheDB); !ok {
+			cache.leases = newLeaseMap()
+		}
+	}
+	if _, ok := primary.(core.ITaggedCacheDB); !ok {
+		cache.tagIndex = newTagIndex()
 	}
+	cache.enableDependency = conf.EnableDependency
+	cache.delayDel = newDelayDeleter(cache)
+	cache.decodeErrorAsMiss = conf.DecodeErrorAsMiss
+	cache.decodeErrLog = newLogLimiter(decodeErrLogInterval)
+	cache.schemaVersion = uint16(conf.Upcast.SchemaVersion)
+	cache.upcasters = make(map[int]core.Upcaster, len(conf.Upcast.Upcasters))
+	for v, fn := range conf.Upcast.Upcasters {
+		cache.upcasters[v] = fn
+	}
+	cache.upcastWriteBack = conf.Upcast.WriteBack
+	if conf.WriteBehind.Enable {
+		cache.writeBehind = newWriteBehind(cache, conf)
+	}
+	if notifier, ok := cache.cacheDB.(core.IEvictNotifier); ok {
+		notifier.SetEvictCallback(func(key string, reason core.EvictReason) {
+			key, ok := cache.userKey(key)
+			if !ok {
+				return
+			}
+			if cache.tagIndex != nil {
+				cache.tagIndex.RemoveKeys(key)
+			}
+			cache.listener.Emit(nil, func(l core.IListener) { l.OnEvict(key, reason) })
+		})
+	}
+
+	return cache, nil
+}
 
-	switch v := strings.ToLower(conf.CacheDB.Type); v {
+/*
+获取最内层被包装的缓存数据库, 用于判断可选接口是否可用.
+
+	包装的缓存数据库(如 fallback)总是实现所有可选接口, 被包装的缓存数据库不支持时调用会返回错误, 所以不能只对 c.cacheDB 做类型断言
+*/
+func unwrapCacheDB(db core.ICacheDB) core.ICacheDB {
+	for {
+		w, ok := db.(core.IWrappedCacheDB)
+		if !ok {
+			return db
+		}
+		db = w.Unwrap()
+	}
+}
+
+// 根据类型创建缓存数据库
+func newCacheDB(dbType string, conf *Config) (core.ICacheDB, error) {
+	switch dbType {
+	case "no":
+		return no_cache.NoCache(), nil
 	case "bigcache":
-		cache.cacheDB, err = bigcache.NewCache(
+		cacheDB, err := bigcache.NewCache(
 			conf.CacheDB.BigCache.Shards,
 			conf.ExpireSec,
 			conf.CacheDB.BigCache.CleanTimeSec,
@@ -85,26 +231,23 @@ func NewCache(name string, conf *Config) (ICache, error) {
 			conf.CacheDB.BigCache.ExactExpire,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("创建bigcache失败: %v", err)
+			return nil, fmt.Errorf("创建bigcache失败: %w", err)
 		}
+		return cacheDB, nil
 	case "freecache":
-		cache.cacheDB = freecache.NewCache(conf.CacheDB.FreeCache.SizeMB)
+		return freecache.NewCache(conf.CacheDB.FreeCache.SizeMB), nil
 	case "redis":
 		var redisClient redis.UniversalClient
+		var err error
 		if conf.CacheDB.RedisName != "" {
 			redisClient = redis.GetClient(conf.CacheDB.RedisName)
 		} else {
 			redisClient, err = redis.NewClient(&conf.CacheDB.Redis, "cache")
 		}
 		if err != nil {
-			return nil, fmt.Errorf("创建redis客户端失败: %v", err)
+			return nil, fmt.Errorf("创建redis客户端失败: %w", err)
 		}
-		cache.cacheDB = redis_cache.NewRedisCache(redisClient)
+		return redis_cache.NewRedisCache(redisClient), nil
 	}
-
-	cache.compactor = GetCompactor(strings.ToLower(conf.Compactor))
-	cache.serializer = GetSerializer(strings.ToLower(conf.Serializer))
-	cache.sf = single_flight.GetSingleFlight(strings.ToLower(conf.SingleFlight))
-
-	return cache, nil
+	return nil, fmt.Errorf("不支持的CacheDB: %v", dbType)
 }
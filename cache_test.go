@@ -3,15 +3,24 @@ package cache
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/zly-app/zapp/component/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 
+	"github.com/zly-app/cache/v2/cachedb/fallback"
+	"github.com/zly-app/cache/v2/cachedb/redis_cache"
 	"github.com/zly-app/cache/v2/core"
 	"github.com/zly-app/cache/v2/errs"
 )
@@ -54,6 +63,7 @@ func TestBigCache(t *testing.T) {
 	t.Run("testSetGetSlice", func(t *testing.T) { testSetGetSlice(t, makeBigCache()) })
 	t.Run("testDel", func(t *testing.T) { testDel(t, makeBigCache()) })
 	t.Run("testExpire", func(t *testing.T) { testExpire(t, makeBigCache()) })
+	t.Run("testExpireAt", func(t *testing.T) { testExpireAt(t, makeBigCache()) })
 	t.Run("testDefaultExpire", func(t *testing.T) {
 		conf := NewConfig()
 		conf.ExpireSec = 1
@@ -66,9 +76,48 @@ func TestBigCache(t *testing.T) {
 		testDefaultExpire(t, cache)
 	})
 	t.Run("testLoadFn", func(t *testing.T) { testLoadFn(t, makeBigCache()) })
+	t.Run("testLoadResult", func(t *testing.T) { testLoadResult(t, makeBigCache()) })
 	t.Run("testClose", func(t *testing.T) { testClose(t, makeBigCache()) })
 	t.Run("testForceLoad", func(t *testing.T) { testForceLoad(t, makeBigCache()) })
 	t.Run("testSF", func(t *testing.T) { testSF(t, makeBigCache()) })
+	t.Run("testListener", func(t *testing.T) { testListener(t, makeBigCache()) })
+	t.Run("testSave", func(t *testing.T) { testSave(t, makeBigCache()) })
+	t.Run("testDelWithDelay", func(t *testing.T) { testDelWithDelay(t, makeBigCache()) })
+	t.Run("testDelByTag", func(t *testing.T) { testDelByTag(t, makeBigCache()) })
+	t.Run("testTagAddOnly", func(t *testing.T) { testTagAddOnly(t, makeBigCache()) })
+	t.Run("testScan", func(t *testing.T) { testScan(t, makeBigCache()) })
+	t.Run("testTTL", func(t *testing.T) {
+		conf := NewConfig()
+		conf.ExpireSec = 10 // bigcache中key的有效期不会超过 ExpireSec
+		conf.CacheDB.Type = "bigcache"
+		conf.CacheDB.BigCache.ExactExpire = true
+		cache, err := NewCache("cachetest_bigcache", conf)
+		if err != nil {
+			panic(fmt.Errorf("创建Cache失败: %v", err))
+		}
+		testTTL(t, cache)
+	})
+	t.Run("testKeyPrefix", func(t *testing.T) {
+		var db core.ICacheDB
+		newCache := func(keyPrefix string, version int) ICache {
+			conf := NewConfig()
+			conf.CacheDB.Type = "bigcache"
+			conf.KeyPrefix = keyPrefix
+			conf.Version = version
+			cache, err := NewCache("cachetest_bigcache", conf)
+			if err != nil {
+				panic(fmt.Errorf("创建Cache失败: %v", err))
+			}
+			// 共用同一个缓存数据库
+			if db == nil {
+				db = cache.(*Cache).cacheDB
+			} else {
+				cache.(*Cache).cacheDB = db
+			}
+			return cache
+		}
+		testKeyPrefix(t, newCache("svc_a", 1), newCache("svc_b", 1), newCache("svc_a", 2))
+	})
 }
 
 func TestFreeCache(t *testing.T) {
@@ -76,6 +125,7 @@ func TestFreeCache(t *testing.T) {
 	t.Run("testSetGetSlice", func(t *testing.T) { testSetGetSlice(t, makeFreeCache()) })
 	t.Run("testDel", func(t *testing.T) { testDel(t, makeFreeCache()) })
 	t.Run("testExpire", func(t *testing.T) { testExpire(t, makeFreeCache()) })
+	t.Run("testExpireAt", func(t *testing.T) { testExpireAt(t, makeFreeCache()) })
 	t.Run("testDefaultExpire", func(t *testing.T) {
 		conf := NewConfig()
 		conf.ExpireSec = 1
@@ -87,9 +137,17 @@ func TestFreeCache(t *testing.T) {
 		testDefaultExpire(t, cache)
 	})
 	t.Run("testLoadFn", func(t *testing.T) { testLoadFn(t, makeFreeCache()) })
+	t.Run("testLoadResult", func(t *testing.T) { testLoadResult(t, makeFreeCache()) })
 	t.Run("testClose", func(t *testing.T) { testClose(t, makeFreeCache()) })
 	t.Run("testForceLoad", func(t *testing.T) { testForceLoad(t, makeFreeCache()) })
 	t.Run("testSF", func(t *testing.T) { testSF(t, makeFreeCache()) })
+	t.Run("testListener", func(t *testing.T) { testListener(t, makeFreeCache()) })
+	t.Run("testSave", func(t *testing.T) { testSave(t, makeFreeCache()) })
+	t.Run("testDelWithDelay", func(t *testing.T) { testDelWithDelay(t, makeFreeCache()) })
+	t.Run("testDelByTag", func(t *testing.T) { testDelByTag(t, makeFreeCache()) })
+	t.Run("testTagAddOnly", func(t *testing.T) { testTagAddOnly(t, makeFreeCache()) })
+	t.Run("testScan", func(t *testing.T) { testScan(t, makeFreeCache()) })
+	t.Run("testTTL", func(t *testing.T) { testTTL(t, makeFreeCache()) })
 }
 
 func TestRedisCache(t *testing.T) {
@@ -97,10 +155,45 @@ func TestRedisCache(t *testing.T) {
 	t.Run("testSetGetSlice", func(t *testing.T) { testSetGetSlice(t, makeRedisCache()) })
 	t.Run("testDel", func(t *testing.T) { testDel(t, makeRedisCache()) })
 	t.Run("testExpire", func(t *testing.T) { testExpire(t, makeRedisCache()) })
+	t.Run("testExpireAt", func(t *testing.T) { testExpireAt(t, makeRedisCache()) })
 	t.Run("testLoadFn", func(t *testing.T) { testLoadFn(t, makeRedisCache()) })
 	t.Run("testClose", func(t *testing.T) { testClose(t, makeRedisCache()) })
 	t.Run("testForceLoad", func(t *testing.T) { testForceLoad(t, makeRedisCache()) })
 	t.Run("testSF", func(t *testing.T) { testSF(t, makeRedisCache()) })
+	t.Run("testTTL", func(t *testing.T) { testTTL(t, makeRedisCache()) })
+	t.Run("testDelByTag", func(t *testing.T) { testDelByTag(t, makeRedisCache()) })
+	t.Run("testTagAddOnly", func(t *testing.T) { testTagAddOnly(t, makeRedisCache()) })
+	t.Run("testKeyPrefix", func(t *testing.T) {
+		newCache := func(keyPrefix string, version int) ICache {
+			conf := NewConfig()
+			conf.CacheDB.Type = "redis"
+			conf.CacheDB.Redis.Address = "localhost:6379"
+			conf.KeyPrefix = keyPrefix
+			conf.Version = version
+			cache, err := NewCache("cachetest_redis", conf)
+			if err != nil {
+				panic(fmt.Errorf("创建Cache失败: %v", err))
+			}
+			return cache
+		}
+		testKeyPrefix(t, newCache("svc_a", 1), newCache("svc_b", 1), newCache("svc_a", 2))
+	})
+	t.Run("testScan", func(t *testing.T) {
+		conf := NewConfig()
+		conf.CacheDB.Type = "redis"
+		conf.CacheDB.Redis.Address = "localhost:6379"
+		conf.KeyPrefix = "cachetest_scan"
+		conf.StaleWriteProtect = true
+		cache, err := NewCache("cachetest_redis", conf)
+		if err != nil {
+			panic(fmt.Errorf("创建Cache失败: %v", err))
+		}
+		testScan(t, cache)
+	})
+	t.Run("testClearWithoutPrefix", func(t *testing.T) {
+		err := makeRedisCache().Clear(context.Background())
+		require.Equal(t, redis_cache.ErrClearWithoutPrefix, err)
+	})
 }
 
 func testSetGet(t *testing.T, cache ICache) {
@@ -149,6 +242,182 @@ func testDel(t *testing.T, cache ICache) {
 	err = cache.Get(context.Background(), key, &b)
 	require.Equal(t, errs.CacheMiss, err)
 }
+func testDelWithDelay(t *testing.T, cache ICache) {
+	const key = "testDelWithDelay"
+
+	err := cache.Set(context.Background(), key, 3)
+	require.Nil(t, err)
+
+	err = cache.DelWithDelay(context.Background(), time.Millisecond*100, key)
+	require.Nil(t, err)
+
+	// 模拟删除后其它请求写回旧数据
+	err = cache.Set(context.Background(), key, 3)
+	require.Nil(t, err)
+
+	time.Sleep(time.Millisecond * 200)
+	var b int
+	err = cache.Get(context.Background(), key, &b)
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 调用后修改传入的切片不影响延迟删除
+	err = cache.Set(context.Background(), key, 3)
+	require.Nil(t, err)
+	keys := []string{key}
+	err = cache.DelWithDelay(context.Background(), time.Millisecond*100, keys...)
+	require.Nil(t, err)
+	keys[0] = key + "_other"
+	err = cache.Set(context.Background(), key, 3)
+	require.Nil(t, err)
+	time.Sleep(time.Millisecond * 200)
+	err = cache.Get(context.Background(), key, &b)
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 关闭时立即执行未到期的删除
+	err = cache.Set(context.Background(), key, 3)
+	require.Nil(t, err)
+	err = cache.DelWithDelay(context.Background(), time.Hour, key)
+	require.Nil(t, err)
+	err = cache.Close()
+	require.Nil(t, err)
+}
+func testDelByTag(t *testing.T, cache ICache) {
+	err := cache.Set(context.Background(), "testDelByTag1", 1, WithTags("tenant:42"))
+	require.Nil(t, err)
+	err = cache.Set(context.Background(), "testDelByTag2", 2, WithTags("tenant:42", "product:9"))
+	require.Nil(t, err)
+	err = cache.Set(context.Background(), "testDelByTag3", 3, WithTags("product:9"))
+	require.Nil(t, err)
+
+	var a int
+	err = cache.Get(context.Background(), "testDelByTag4", &a, WithTags("tenant:42"),
+		WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+			return 4, nil
+		}))
+	require.Nil(t, err)
+
+	err = cache.DelByTag(context.Background(), "tenant:42")
+	require.Nil(t, err)
+
+	for _, key := range []string{"testDelByTag1", "testDelByTag2", "testDelByTag4"} {
+		err = cache.Get(context.Background(), key, &a)
+		require.Equal(t, errs.CacheMiss, err)
+	}
+	err = cache.Get(context.Background(), "testDelByTag3", &a)
+	require.Nil(t, err)
+	require.Equal(t, 3, a)
+}
+
+// 标签只会增加, 重新写入时带上新的标签不会移除key原有的标签
+func testTagAddOnly(t *testing.T, cache ICache) {
+	ctx := context.Background()
+	const key = "testTagAddOnly"
+	err := cache.Set(ctx, key, 1, WithTags("addOnly:a"))
+	require.Nil(t, err)
+	err = cache.Set(ctx, key, 2, WithTags("addOnly:b"))
+	require.Nil(t, err)
+
+	var a int
+	err = cache.DelByTag(ctx, "addOnly:a")
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, errs.CacheMiss, err)
+
+	err = cache.Set(ctx, key, 3, WithTags("addOnly:b"))
+	require.Nil(t, err)
+	err = cache.DelByTag(ctx, "addOnly:b")
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, errs.CacheMiss, err)
+}
+
+func testKeyPrefix(t *testing.T, cache, otherPrefix, otherVersion ICache) {
+	const key = "testKeyPrefix"
+
+	err := cache.Set(context.Background(), key, 3)
+	require.Nil(t, err)
+	defer cache.Del(context.Background(), key)
+
+	var a int
+	err = cache.Get(context.Background(), key, &a)
+	require.Nil(t, err)
+	require.Equal(t, 3, a)
+
+	err = otherPrefix.Get(context.Background(), key, &a)
+	require.Equal(t, errs.CacheMiss, err)
+	err = otherVersion.Get(context.Background(), key, &a)
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 标签也按前缀和版本隔离
+	err = cache.Set(context.Background(), key, 3, WithTags("testKeyPrefix"))
+	require.Nil(t, err)
+	err = otherPrefix.Set(context.Background(), key, 4, WithTags("testKeyPrefix"))
+	require.Nil(t, err)
+	defer otherPrefix.Del(context.Background(), key)
+	err = otherPrefix.DelByTag(context.Background(), "testKeyPrefix")
+	require.Nil(t, err)
+	err = cache.Get(context.Background(), key, &a)
+	require.Nil(t, err)
+	require.Equal(t, 3, a)
+}
+func testScan(t *testing.T, cache ICache) {
+	ctx := context.Background()
+	for _, key := range []string{"order:1:a", "order:1:b", "order:2:a", "user:1"} {
+		err := cache.Set(ctx, key, 1)
+		require.Nil(t, err)
+	}
+
+	var keys []string
+	err := cache.Scan(ctx, "order:1:", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"order:1:a", "order:1:b"}, keys)
+
+	err = cache.DelPrefix(ctx, "order:")
+	require.Nil(t, err)
+
+	var a int
+	err = cache.Get(ctx, "order:2:a", &a)
+	require.Equal(t, errs.CacheMiss, err)
+	err = cache.Get(ctx, "user:1", &a)
+	require.Nil(t, err)
+
+	err = cache.Clear(ctx)
+	require.Nil(t, err)
+	err = cache.Get(ctx, "user:1", &a)
+	require.Equal(t, errs.CacheMiss, err)
+}
+func testTTL(t *testing.T, cache ICache) {
+	ctx := context.Background()
+	const key = "testTTL"
+
+	exists, err := cache.Exists(ctx, key)
+	require.Nil(t, err)
+	require.False(t, exists)
+	_, err = cache.TTL(ctx, key)
+	require.Equal(t, errs.CacheMiss, err)
+	err = cache.Touch(ctx, key, 10)
+	require.Equal(t, errs.CacheMiss, err)
+
+	err = cache.Set(ctx, key, 3, WithExpire(1))
+	require.Nil(t, err)
+	exists, err = cache.Exists(ctx, key)
+	require.Nil(t, err)
+	require.True(t, exists)
+	ttl, err := cache.TTL(ctx, key)
+	require.Nil(t, err)
+	require.True(t, ttl > 0 && ttl <= time.Second)
+
+	// 延长有效期
+	err = cache.Touch(ctx, key, 3)
+	require.Nil(t, err)
+	time.Sleep(time.Millisecond * 1500)
+	exists, err = cache.Exists(ctx, key)
+	require.Nil(t, err)
+	require.True(t, exists)
+}
 func testExpire(t *testing.T, cache ICache) {
 	const key = "testExpire"
 
@@ -167,6 +436,45 @@ func testExpire(t *testing.T, cache ICache) {
 	err = cache.Get(context.Background(), key, &c)
 	require.Equal(t, errs.CacheMiss, err)
 }
+func testExpireAt(t *testing.T, cache ICache) {
+	ctx := context.Background()
+	const key = "testExpireAt"
+
+	var a int
+	err := cache.Set(ctx, key, 3, WithTTL(time.Millisecond*300))
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a)
+	require.Nil(t, err)
+	time.Sleep(time.Millisecond * 400)
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, errs.CacheMiss, err)
+
+	err = cache.Set(ctx, key, 3, WithExpireAt(time.Now().Add(time.Millisecond*300)))
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a)
+	require.Nil(t, err)
+	time.Sleep(time.Millisecond * 400)
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 过期时间已过, 不写入并删除旧数据
+	err = cache.Set(ctx, key, 3)
+	require.Nil(t, err)
+	err = cache.Set(ctx, key, 4, WithExpireAt(time.Now().Add(-time.Second)))
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 过期时间已过, 加载的数据不写入缓存
+	err = cache.Get(ctx, key, &a, WithExpireAt(time.Now().Add(-time.Second)), WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return 5, nil
+	}))
+	require.Nil(t, err)
+	require.Equal(t, 5, a)
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, errs.CacheMiss, err)
+}
+
 func testDefaultExpire(t *testing.T, cache ICache) {
 	const key = "testDefaultExpire"
 
@@ -201,6 +509,51 @@ func testLoadFn(t *testing.T, cache ICache) {
 	require.Equal(t, true, load)
 	require.Equal(t, a, b)
 }
+func testLoadResult(t *testing.T, cache ICache) {
+	ctx := context.Background()
+	const key = "testLoadResult"
+
+	// 加载函数指定有效期
+	var b int
+	err := cache.Get(ctx, key, &b, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return &LoadResult{Value: 3, TTL: time.Millisecond * 300}, nil
+	}))
+	require.Nil(t, err)
+	require.Equal(t, 3, b)
+	err = cache.Get(ctx, key, &b)
+	require.Nil(t, err)
+	time.Sleep(time.Millisecond * 400)
+	err = cache.Get(ctx, key, &b)
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 加载函数指定不写入缓存
+	err = cache.Get(ctx, key, &b, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return LoadResult{Value: 4, DontCache: true}, nil
+	}))
+	require.Nil(t, err)
+	require.Equal(t, 4, b)
+	err = cache.Get(ctx, key, &b)
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 返回nil的加载结果按nil数据处理
+	err = cache.Get(ctx, key, &b, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return (*LoadResult)(nil), nil
+	}))
+	require.Equal(t, ErrDataIsNil, err)
+	err = cache.Get(ctx, key, &b)
+	require.Equal(t, ErrDataIsNil, err)
+	require.Nil(t, cache.Del(ctx, key))
+
+	// 不满足条件时不写入缓存
+	var s []int
+	err = cache.Get(ctx, key, &s, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return []int{}, nil
+	}), WithCacheIf(func(v interface{}) bool { return len(v.([]int)) > 0 }))
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &s)
+	require.Equal(t, errs.CacheMiss, err)
+}
+
 func testClose(t *testing.T, cache ICache) {
 	const key = "testClose"
 
@@ -267,82 +620,1129 @@ func testSF(t *testing.T, cache ICache) {
 	require.Equal(t, true, loadB)
 	require.Equal(t, true, loadC)
 }
+func testSave(t *testing.T, cache ICache) {
+	const key = "testSave"
 
-func BenchmarkGet(b *testing.B) {
-	keyCount := []struct {
-		name   string
-		count  int
-		sizeMB int
-	}{
-		{"1k", 1000, 10},
-		//{"10k", 10000, 100},
-		//{"30k", 100000, 300},
-	}
-	compactors := []struct {
-		name string
-		core.ICompactor
-	}{
-		{"NoCompactor", NoCompactor},
-		//{"ZStdCompactor", ZStdCompactor},
-		//{"GzipCompactor", GzipCompactor},
+	var a = 3
+	var saved interface{}
+	err := cache.Save(context.Background(), key, a, WithSaveFn(func(ctx context.Context, key string, data interface{}) error {
+		saved = data
+		return nil
+	}))
+	require.Nil(t, err)
+	require.Equal(t, a, saved)
+
+	var b int
+	err = cache.Get(context.Background(), key, &b)
+	require.Nil(t, err)
+	require.Equal(t, a, b)
+}
+
+func TestWriteBehind(t *testing.T) {
+	conf := NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.WriteBehind.Enable = true
+	cache, err := NewCache("cachetest_write_behind", conf)
+	require.Nil(t, err)
+
+	const key = "testWriteBehind"
+	var mx sync.Mutex
+	var saved []interface{}
+	saveFn := WithSaveFn(func(ctx context.Context, key string, data interface{}) error {
+		mx.Lock()
+		saved = append(saved, data)
+		mx.Unlock()
+		return nil
+	})
+	for i := 1; i <= 3; i++ {
+		err = cache.Save(context.Background(), key, i, saveFn, WithWriteBehind())
+		require.Nil(t, err)
 	}
-	serializers := []struct {
-		name string
-		core.ISerializer
-	}{
-		{"MsgPackSerializer", MsgPackSerializer},
-		//{"JsonIterStandardSerializer", JsonIterStandardSerializer},
-		//{"JsonSerializer", JsonSerializer},
-		//{"JsonIterSerializer", JsonIterSerializer},
-		//{"YamlSerializer", YamlSerializer},
+
+	var b int
+	err = cache.Get(context.Background(), key, &b)
+	require.Nil(t, err)
+	require.Equal(t, 3, b)
+
+	// SaveFn 收到的是传给 Save 的数据本身
+	type User struct {
+		Name string
 	}
-	for _, k := range keyCount {
-		for _, c := range compactors {
-			for _, s := range serializers {
-				name := fmt.Sprintf("%v_%v_%v", k.name, s.name, c.name)
-				b.Run(name, func(b *testing.B) {
-					benchGet(b, k.count, k.sizeMB, s, c)
-				})
-			}
-		}
+	u := &User{Name: "a"}
+	err = cache.Save(context.Background(), key+"_same", u, saveFn, WithWriteBehind())
+	require.Nil(t, err)
+
+	// 写入缓存失败时不放入队列
+	c := cache.(*Cache)
+	db := c.cacheDB
+	fakeDB := newTestFakeCacheDB()
+	fakeDB.inject(errors.New("db down"), 0)
+	c.cacheDB = fakeDB
+	err = cache.Save(context.Background(), key+"_fail", 4, saveFn, WithWriteBehind())
+	var faultErr *CacheFaultError
+	require.True(t, errors.As(err, &faultErr))
+	c.cacheDB = db
+
+	err = cache.Close()
+	require.Nil(t, err)
+	require.NotEmpty(t, saved)
+	require.Contains(t, saved, 3)
+	var found bool
+	for _, v := range saved {
+		found = found || v == interface{}(u)
 	}
+	require.True(t, found)
+	require.NotContains(t, saved, 4)
 }
 
-func benchGet(b *testing.B, maxKeyCount, sizeMB int, serializer core.ISerializer, compactor core.ICompactor) {
-	rand.Seed(time.Now().UnixNano())
-	const dataLen = 512
+func TestLoaderMinInterval(t *testing.T) {
+	conf := NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.Loader.MinIntervalMs = 1000
+	cache, err := NewCache("cachetest_loader", conf)
+	require.Nil(t, err)
+
+	const key = "testLoaderMinInterval"
+	load := WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return 3, nil
+	})
+
+	var a int
+	err = cache.Get(context.Background(), key, &a, load, WithForceLoad(true))
+	require.Nil(t, err)
+	require.Equal(t, 3, a)
+
+	err = cache.Get(context.Background(), key, &a, load, WithForceLoad(true))
+	require.True(t, errors.Is(err, ErrLoadTooFrequent))
+	var loadErr *LoadError
+	require.True(t, errors.As(err, &loadErr))
+	require.Equal(t, errs.StageLoad, loadErr.Stage)
+
+	// 加载失败同样记录加载时间
+	const failKey = "testLoaderMinInterval_fail"
+	failErr := errors.New("load failed")
+	err = cache.Get(context.Background(), failKey, &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return nil, failErr
+	}))
+	require.True(t, errors.Is(err, failErr))
+	err = cache.Get(context.Background(), failKey, &a, load)
+	require.True(t, errors.Is(err, ErrLoadTooFrequent))
+}
+
+func TestLoaderOverloaded(t *testing.T) {
+	testLoaderOverloaded := func(t *testing.T, cache ICache) {
+		started, done := make(chan struct{}), make(chan struct{})
+		go func() {
+			var a int
+			_ = cache.Get(context.Background(), "testLoaderOverloaded_1", &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+				close(started)
+				<-done
+				return 1, nil
+			}))
+		}()
+		<-started
+		defer close(done)
+
+		var a int
+		startTime := time.Now()
+		err := cache.Get(context.Background(), "testLoaderOverloaded_2", &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+			return 2, nil
+		}))
+		require.True(t, errors.Is(err, ErrLoaderOverloaded))
+		require.True(t, time.Since(startTime) >= 100*time.Millisecond)
+	}
+
+	// cache加载并发限制
+	t.Run("cache", func(t *testing.T) {
+		conf := NewConfig()
+		conf.CacheDB.Type = "bigcache"
+		conf.Loader.MaxConcurrency = 1
+		conf.Loader.QueueTimeoutMs = 100
+		cache, err := NewCache("cachetest_loader_overloaded", conf)
+		require.Nil(t, err)
+		testLoaderOverloaded(t, cache)
+	})
+
+	// 只有全局加载并发限制时排队超时同样生效
+	t.Run("global", func(t *testing.T) {
+		SetGlobalLoaderLimit(1)
+		defer SetGlobalLoaderLimit(0)
+		conf := NewConfig()
+		conf.CacheDB.Type = "bigcache"
+		conf.Loader.QueueTimeoutMs = 100
+		cache, err := NewCache("cachetest_loader_overloaded_global", conf)
+		require.Nil(t, err)
+		testLoaderOverloaded(t, cache)
+	})
+}
 
+func TestErrorCache(t *testing.T) {
 	conf := NewConfig()
-	conf.CacheDB.FreeCache.SizeMB = sizeMB
-	cache, err := NewCache("cachetest_benchGet", conf)
-	require.Nil(b, err)
+	conf.CacheDB.Type = "bigcache"
+	conf.ErrorCache.Enable = true
+	conf.ErrorCache.BackoffMs = 200
+	conf.ErrorCache.MaxBackoffMs = 1000
+	cache, err := NewCache("cachetest_errcache", conf)
+	require.Nil(t, err)
 
-	expects := make([][]byte, maxKeyCount)
-	for i := 0; i < maxKeyCount; i++ {
-		bs := make([]byte, dataLen)
-		for j := 0; j < dataLen; j++ {
-			bs[j] = byte(rand.Int() & 255)
+	const key = "testErrorCache"
+	loadErr := errors.New("upstream error")
+	var calls int
+	fail := true
+	load := WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		calls++
+		if fail {
+			return nil, loadErr
 		}
-		expects[i] = bs
+		return 3, nil
+	})
 
-		key := strconv.Itoa(i)
-		err := cache.Set(context.Background(), key, bs, WithSerializer(serializer), WithCompactor(compactor))
-		require.NoError(b, err, "数据设置失败")
-	}
+	var a int
+	err = cache.Get(context.Background(), key, &a, load)
+	require.NotNil(t, err)
+	require.Equal(t, 1, calls)
 
-	// 缓存随机key
-	randKeys := make([]int, 1<<20)
-	for i := 0; i < len(randKeys); i++ {
-		randKeys[i] = rand.Int() % maxKeyCount
-	}
+	// 退避期间不调用加载函数
+	err = cache.Get(context.Background(), key, &a, load)
+	var cached *CachedLoadError
+	require.True(t, errors.As(err, &cached))
+	require.Equal(t, loadErr, cached.Err)
+	var le *LoadError
+	require.True(t, errors.As(err, &le))
+	require.Equal(t, errs.StageLoad, le.Stage)
+	require.True(t, errors.Is(err, loadErr))
+	require.Equal(t, 1, calls)
 
-	b.ResetTimer()
-	b.RunParallel(func(p *testing.PB) {
-		i := 0
-		for p.Next() {
-			i++
-			key := randKeys[i&(len(randKeys)-1)]
-			expect := expects[key]
+	// 再次失败后退避时间翻倍
+	time.Sleep(time.Millisecond * 250)
+	err = cache.Get(context.Background(), key, &a, load)
+	require.NotNil(t, err)
+	require.Equal(t, 2, calls)
+	time.Sleep(time.Millisecond * 250)
+	err = cache.Get(context.Background(), key, &a, load)
+	require.True(t, errors.As(err, &cached))
+	require.Equal(t, 2, calls)
+
+	// 加载成功后重置
+	fail = false
+	time.Sleep(time.Millisecond * 200)
+	err = cache.Get(context.Background(), key, &a, load)
+	require.Nil(t, err)
+	require.Equal(t, 3, a)
+	require.Equal(t, 3, calls)
+
+	// 取消或超时导致的失败不缓存
+	const ctxKey = "testErrorCache_ctx"
+	for _, ctxErr := range []error{context.Canceled, context.DeadlineExceeded} {
+		err = cache.Get(context.Background(), ctxKey, &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+			return nil, fmt.Errorf("query: %w", ctxErr)
+		}))
+		require.True(t, errors.Is(err, ctxErr))
+		require.False(t, errors.As(err, &cached))
+	}
+	err = cache.Get(context.Background(), ctxKey, &a, load)
+	require.Nil(t, err)
+}
+
+func TestErrorTypes(t *testing.T) {
+	cache := makeBigCache()
+	ctx := context.Background()
+
+	// 加载函数的错误
+	notFound := errors.New("not found")
+	var a int
+	err := cache.Get(ctx, "testErrorTypes_load", &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return nil, fmt.Errorf("query: %w", notFound)
+	}))
+	var loadErr *LoadError
+	require.True(t, errors.As(err, &loadErr))
+	require.Equal(t, "testErrorTypes_load", loadErr.Key)
+	require.Equal(t, errs.StageLoad, loadErr.Stage)
+	require.True(t, errors.Is(err, notFound))
+
+	// 加载函数的ctx超时
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	err = cache.Get(timeoutCtx, "testErrorTypes_timeout", &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	require.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// 解码失败
+	err = cache.Set(ctx, "testErrorTypes_codec", "abc")
+	require.Nil(t, err)
+	err = cache.Get(ctx, "testErrorTypes_codec", &a)
+	var codecErr *CodecError
+	require.True(t, errors.As(err, &codecErr))
+	require.Equal(t, errs.StageDeserialize, codecErr.Stage)
+	require.False(t, errors.As(err, &loadErr))
+}
+
+func TestDecodeErrorAsMiss(t *testing.T) {
+	conf := NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.DecodeErrorAsMiss = true
+	cache, err := NewCache("cachetest_decode", conf)
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	const key = "testDecodeErrorAsMiss"
+
+	// 没有加载函数时视为未命中
+	err = cache.Set(ctx, key, "abc")
+	require.Nil(t, err)
+	var a int
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, ErrCacheMiss, err)
+
+	// 重新加载并覆盖
+	err = cache.Set(ctx, key, "abc")
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return 3, nil
+	}))
+	require.Nil(t, err)
+	require.Equal(t, 3, a)
+
+	a = 0
+	err = cache.Get(ctx, key, &a)
+	require.Nil(t, err)
+	require.Equal(t, 3, a)
+}
+
+func TestValueEnvelope(t *testing.T) {
+	cache := makeBigCache()
+	ctx := context.Background()
+	const key = "testValueEnvelope"
+	type A struct {
+		A int
+		B string
+	}
+
+	// 使用信封中记录的编解码器解码
+	err := cache.Set(ctx, key, A{1, "a"}, WithSerializer(MsgPackSerializer), WithCompactor(GetCompactor("gzip")))
+	require.Nil(t, err)
+	var a A
+	err = cache.Get(ctx, key, &a)
+	require.Nil(t, err)
+	require.Equal(t, A{1, "a"}, a)
+
+	// 没有信封的旧数据
+	c := cache.(*Cache)
+	err = c.unmarshalQuery(key, []byte(`{"A":2,"B":"b"}`), &a, JsonSerializer, GetCompactor("raw"))
+	require.Nil(t, err)
+	require.Equal(t, A{2, "b"}, a)
+
+	// 不支持的格式版本
+	bs := encodeEnvelope(&envelope{Version: envelopeVersion + 1, CreatedAt: time.Now()}, []byte("{}"))
+	err = c.unmarshalQuery(key, bs, &a, JsonSerializer, GetCompactor("raw"))
+	var codecErr *CodecError
+	require.True(t, errors.As(err, &codecErr))
+	require.Equal(t, errs.StageEnvelope, codecErr.Stage)
+	err = c.dbSet(ctx, key, bs, 0, time.Time{})
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a)
+	require.True(t, errors.As(err, &codecErr))
+	require.Equal(t, errs.StageEnvelope, codecErr.Stage)
+
+	// 恰好以信封标识开头的旧数据, 头部校验不通过时按旧数据解码
+	raw := append([]byte{0x00, 0xcf}, bytes.Repeat([]byte("x"), envelopeHeaderSize)...)
+	var out []byte
+	err = c.unmarshalQuery(key, raw, &out, GetSerializer("bytes"), GetCompactor("raw"))
+	require.Nil(t, err)
+	require.Equal(t, raw, out)
+
+	// nil数据
+	err = cache.Set(ctx, key, nil)
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, ErrDataIsNil, err)
+}
+
+func TestUpcast(t *testing.T) {
+	type User struct {
+		Name string
+		Age  int
+	}
+	upcasters := map[int]core.Upcaster{
+		0: func(data []byte, serializer core.ISerializer) ([]byte, error) { // name -> Name
+			var m map[string]interface{}
+			if err := serializer.UnmarshalBytes(data, &m); err != nil {
+				return nil, err
+			}
+			m["Name"] = m["name"]
+			delete(m, "name")
+			return serializer.MarshalBytes(m)
+		},
+		1: func(data []byte, serializer core.ISerializer) ([]byte, error) { // 新增 Age
+			var m map[string]interface{}
+			if err := serializer.UnmarshalBytes(data, &m); err != nil {
+				return nil, err
+			}
+			m["Age"] = 18
+			return serializer.MarshalBytes(m)
+		},
+	}
+
+	conf := NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.Serializer = "json"
+	conf.Upcast.SchemaVersion = 2
+	conf.Upcast.Upcasters = map[int]core.Upcaster{2: upcasters[0]}
+	_, err := NewCache("cachetest_upcast", conf)
+	require.Error(t, err)
+
+	conf.Upcast.Upcasters = upcasters
+	conf.Upcast.WriteBack = true
+	cache, err := NewCache("cachetest_upcast", conf)
+	require.Nil(t, err)
+	c := cache.(*Cache)
+
+	ctx := context.Background()
+	const key = "testUpcast"
+	old := encodeEnvelope(&envelope{Version: envelopeVersion, SerializerID: serializerIDs["json"], CompactorID: compactorIDs["raw"], CreatedAt: time.Now()}, []byte(`{"name":"a"}`))
+	err = c.dbSet(ctx, key, old, 0, time.Time{})
+	require.Nil(t, err)
+
+	var u User
+	err = cache.Get(ctx, key, &u)
+	require.Nil(t, err)
+	require.Equal(t, User{"a", 18}, u)
+
+	// 升级后的数据已写回
+	bs, err := c.dbGet(ctx, key)
+	require.Nil(t, err)
+	env, _, err := decodeEnvelope(bs)
+	require.Nil(t, err)
+	require.Equal(t, uint16(2), env.SchemaVersion)
+
+	// 没有信封的旧数据版本为0
+	err = c.dbSet(ctx, key, []byte(`{"name":"b"}`), 0, time.Time{})
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &u)
+	require.Nil(t, err)
+	require.Equal(t, User{"b", 18}, u)
+
+	// 写回前数据已被修改时放弃写回
+	err = c.dbSet(ctx, key, old, 0, time.Time{})
+	require.Nil(t, err)
+	err = cache.Set(ctx, key, User{"d", 1})
+	require.Nil(t, err)
+	c.writeBackUpcast(ctx, key, old, encodeEnvelope(&envelope{Version: envelopeVersion}, []byte(`{}`)), &envelope{})
+	err = cache.Get(ctx, key, &u)
+	require.Nil(t, err)
+	require.Equal(t, User{"d", 1}, u)
+}
+
+func TestDependency(t *testing.T) {
+	conf := NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.EnableDependency = true
+	cache, err := NewCache("cachetest_dependency", conf)
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	err = cache.Set(ctx, "product:1", 1)
+	require.Nil(t, err)
+	err = cache.Set(ctx, "product:2", 2)
+	require.Nil(t, err)
+
+	// page 依赖 product:1 和 product:2, home 依赖 page
+	loadPage := WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		var a, b int
+		if err := cache.Get(ctx, "product:1", &a); err != nil {
+			return nil, err
+		}
+		if err := cache.Get(ctx, "product:2", &b); err != nil {
+			return nil, err
+		}
+		return a + b, nil
+	})
+	loadHome := WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		var page int
+		err := cache.Get(ctx, "page", &page, loadPage)
+		return page * 10, err
+	})
+
+	var a int
+	err = cache.Get(ctx, "home", &a, loadHome)
+	require.Nil(t, err)
+	require.Equal(t, 30, a)
+
+	// 更新依赖会递归删除依赖它的key
+	err = cache.Set(ctx, "product:2", 5)
+	require.Nil(t, err)
+	err = cache.Get(ctx, "page", &a)
+	require.Equal(t, ErrCacheMiss, err)
+	err = cache.Get(ctx, "home", &a)
+	require.Equal(t, ErrCacheMiss, err)
+
+	err = cache.Get(ctx, "home", &a, loadHome)
+	require.Nil(t, err)
+	require.Equal(t, 60, a)
+
+	// 删除依赖
+	err = cache.Del(ctx, "product:1")
+	require.Nil(t, err)
+	err = cache.Get(ctx, "home", &a)
+	require.Equal(t, ErrCacheMiss, err)
+}
+
+func TestStaleWriteProtect(t *testing.T) {
+	conf := NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.StaleWriteProtect = true
+	cache, err := NewCache("cachetest_stale", conf)
+	require.Nil(t, err)
+
+	const key = "testStaleWriteProtect"
+	loading, done := make(chan struct{}), make(chan struct{})
+	load := WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		close(loading)
+		<-done
+		return 1, nil
+	})
+
+	errCh := make(chan error, 1)
+	go func() {
+		var a int
+		errCh <- cache.Get(context.Background(), key, &a, load)
+	}()
+
+	<-loading
+	err = cache.Del(context.Background(), key)
+	require.Nil(t, err)
+	close(done)
+	require.Nil(t, <-errCh)
+
+	var a int
+	err = cache.Get(context.Background(), key, &a)
+	require.Equal(t, ErrCacheMiss, err)
+}
+
+func TestSlidingExpireConcurrentDel(t *testing.T) {
+	for _, dbType := range []string{"bigcache", "freecache"} {
+		t.Run(dbType, func(t *testing.T) {
+			conf := NewConfig()
+			conf.CacheDB.Type = dbType
+			conf.SlidingExpire.Enable = true
+			cache, err := NewCache("cachetest_sliding_del", conf)
+			require.Nil(t, err)
+
+			ctx := context.Background()
+			const key = "testSlidingExpireConcurrentDel"
+			for i := 0; i < 100; i++ {
+				err = cache.Set(ctx, key, i)
+				require.Nil(t, err)
+
+				var wg sync.WaitGroup
+				for j := 0; j < 4; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						var a int
+						_ = cache.Get(ctx, key, &a)
+					}()
+				}
+				err = cache.Del(ctx, key)
+				require.Nil(t, err)
+				wg.Wait()
+
+				// 重置有效期不会写回已删除的数据
+				var a int
+				err = cache.Get(ctx, key, &a)
+				require.Equal(t, ErrCacheMiss, err)
+			}
+		})
+	}
+}
+
+func TestSlidingExpire(t *testing.T) {
+	conf := NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.ExpireSec = 10
+	conf.SlidingExpire.Enable = true
+	conf.SlidingExpire.MaxLifetimeSec = 3
+	cache, err := NewCache("cachetest_sliding", conf)
+	require.Nil(t, err)
+
+	ctx := context.Background()
+	const key = "testSlidingExpire"
+	err = cache.Set(ctx, key, 1, WithExpire(1))
+	require.Nil(t, err)
+
+	// 命中时重置有效期
+	var a int
+	for i := 0; i < 2; i++ {
+		time.Sleep(time.Millisecond * 700)
+		err = cache.Get(ctx, key, &a, WithExpire(1))
+		require.Nil(t, err)
+		require.Equal(t, 1, a)
+	}
+
+	// 不启用滑动过期时不重置有效期
+	time.Sleep(time.Millisecond * 700)
+	err = cache.Get(ctx, key, &a, WithSlidingExpire(false))
+	require.Nil(t, err)
+	time.Sleep(time.Millisecond * 400)
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, ErrCacheMiss, err)
+
+	// 超过最大存活时间后过期
+	err = cache.Set(ctx, key, 1, WithExpire(1))
+	require.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		time.Sleep(time.Millisecond * 700)
+		err = cache.Get(ctx, key, &a, WithExpire(1))
+		if i < 4 {
+			require.Nil(t, err)
+		}
+	}
+	require.Equal(t, ErrCacheMiss, err)
+}
+
+// 测试用的缓存数据库, 可以注入延迟和错误
+type testFakeCacheDB struct {
+	mx     sync.Mutex
+	data   map[string][]byte
+	calls  int
+	err    error           // 不为nil时调用返回该错误
+	failN  int             // 大于0时只有前 failN 次调用返回错误
+	delays []time.Duration // 第i次调用的延迟
+}
+
+func newTestFakeCacheDB() *testFakeCacheDB {
+	return &testFakeCacheDB{data: make(map[string][]byte)}
+}
+
+func (f *testFakeCacheDB) inject(err error, failN int, delays ...time.Duration) {
+	f.mx.Lock()
+	f.calls, f.err, f.failN, f.delays = 0, err, failN, delays
+	f.mx.Unlock()
+}
+
+func (f *testFakeCacheDB) Calls() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return f.calls
+}
+
+func (f *testFakeCacheDB) do(ctx context.Context) error {
+	f.mx.Lock()
+	i := f.calls
+	f.calls++
+	fail := f.err != nil && (f.failN <= 0 || i < f.failN)
+	var delay time.Duration
+	if i < len(f.delays) {
+		delay = f.delays[i]
+	}
+	err := f.err
+	f.mx.Unlock()
+
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	if fail {
+		return err
+	}
+	return nil
+}
+
+func (f *testFakeCacheDB) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := f.do(ctx); err != nil {
+		return nil, err
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	bs, ok := f.data[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return bs, nil
+}
+
+func (f *testFakeCacheDB) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	if err := f.do(ctx); err != nil {
+		return err
+	}
+	f.mx.Lock()
+	f.data[key] = data
+	f.mx.Unlock()
+	return nil
+}
+
+func (f *testFakeCacheDB) Del(ctx context.Context, keys ...string) error {
+	if err := f.do(ctx); err != nil {
+		return err
+	}
+	f.mx.Lock()
+	for _, key := range keys {
+		delete(f.data, key)
+	}
+	f.mx.Unlock()
+	return nil
+}
+
+func (f *testFakeCacheDB) Close() error { return nil }
+
+func TestFallbackCapability(t *testing.T) {
+	cache, err := NewCache("cachetest_fallback_capability", NewConfig())
+	require.Nil(t, err)
+	c := cache.(*Cache)
+	primary := newTestFakeCacheDB()
+	c.cacheDB = fallback.NewCache(primary, func() (core.ICacheDB, error) {
+		return newTestFakeCacheDB(), nil
+	}, 1, 1)
+	_, c.touchable = unwrapCacheDB(c.cacheDB).(core.IExpireCacheDB)
+	ctx := context.Background()
+
+	// 主缓存数据库不支持的可选接口不可用, 并且不会导致切换到备用缓存数据库
+	_, err = cache.Exists(ctx, "a")
+	require.ErrorIs(t, err, errExpireNotSupported)
+	_, err = cache.TTL(ctx, "a")
+	require.ErrorIs(t, err, errExpireNotSupported)
+	require.Nil(t, cache.Set(ctx, "a", 1, WithExpireAt(time.Now().Add(time.Minute))))
+	var a int
+	require.Nil(t, cache.Get(ctx, "a", &a, WithSlidingExpire(true)))
+	require.Equal(t, 1, a)
+
+	// 数据写入了主缓存数据库
+	primary.mx.Lock()
+	_, ok := primary.data["a"]
+	primary.mx.Unlock()
+	require.True(t, ok)
+}
+
+type testClearGuardCacheDB struct {
+	*testFakeCacheDB
+}
+
+func (*testClearGuardCacheDB) CheckClear() error { return redis_cache.ErrClearWithoutPrefix }
+
+func TestClearGuard(t *testing.T) {
+	cache, err := NewCache("cachetest_clear_guard", NewConfig())
+	require.Nil(t, err)
+	c := cache.(*Cache)
+	c.cacheDB = fallback.NewCache(&testClearGuardCacheDB{newTestFakeCacheDB()}, func() (core.ICacheDB, error) {
+		return newTestFakeCacheDB(), nil
+	}, 1, 1)
+	ctx := context.Background()
+
+	// 以被包装的缓存数据库为准
+	require.Equal(t, redis_cache.ErrClearWithoutPrefix, cache.Clear(ctx))
+	require.Equal(t, redis_cache.ErrClearWithoutPrefix, cache.DelPrefix(ctx, ""))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	conf := NewConfig()
+	conf.CacheDB.Type = "freecache"
+	conf.IgnoreCacheFault = false
+	conf.CircuitBreaker.Enable = true
+	conf.CircuitBreaker.MinRequests = 2
+	conf.CircuitBreaker.ErrorRate = 0.5
+	conf.CircuitBreaker.CooldownSec = 1
+	conf.CircuitBreaker.HalfOpenProbes = 1
+	cache, err := NewCache("cachetest_breaker", conf)
+	require.Nil(t, err)
+	c := cache.(*Cache)
+	db := newTestFakeCacheDB()
+	c.cacheDB = db
+
+	dbErr := errors.New("db down")
+	steps := []struct {
+		name      string
+		wait      time.Duration // 执行前等待的时间
+		dbErr     error
+		wantErr   error // 为nil时表示未命中
+		wantCalls int   // 调用缓存数据库的次数, 未命中时包含加载后的写入
+		wantState breakerState
+	}{
+		{"closed失败未达到最小请求数", 0, dbErr, dbErr, 1, breakerClosed},
+		{"closed失败率达到阈值后打开", 0, dbErr, dbErr, 1, breakerOpen},
+		{"open时拒绝请求", 0, nil, ErrCircuitOpen, 0, breakerOpen},
+		{"冷却后half-open探测失败重新打开", time.Second + 100*time.Millisecond, dbErr, dbErr, 1, breakerOpen},
+		{"冷却期内继续拒绝", 0, nil, ErrCircuitOpen, 0, breakerOpen},
+		{"冷却后half-open探测成功后关闭", time.Second + 100*time.Millisecond, nil, nil, 2, breakerClosed},
+		{"closed正常请求", 0, nil, nil, 2, breakerClosed},
+	}
+	for _, s := range steps {
+		time.Sleep(s.wait)
+		db.inject(s.dbErr, 0)
+
+		var loaded bool
+		var a int
+		err := cache.Get(context.Background(), "testCircuitBreaker", &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+			loaded = true
+			return 1, nil
+		}))
+		require.Equal(t, s.wantCalls, db.Calls(), s.name)
+		require.Equal(t, s.wantState, testBreakerState(c.breaker), s.name)
+		if s.wantErr == nil {
+			require.Nil(t, err, s.name)
+			require.True(t, loaded, s.name)
+			require.Nil(t, cache.Del(context.Background(), "testCircuitBreaker"), s.name)
+		} else {
+			require.True(t, errors.Is(err, s.wantErr), s.name)
+			var faultErr *CacheFaultError
+			require.True(t, errors.As(err, &faultErr), s.name)
+			require.False(t, loaded, s.name)
+		}
+	}
+
+	// 忽略缓存故障时熔断器打开后回源加载
+	c.ignoreCacheFault = true
+	db.inject(dbErr, 0)
+	var a int
+	for i := 0; i < 10 && testBreakerState(c.breaker) != breakerOpen; i++ {
+		_ = cache.Get(context.Background(), "testCircuitBreaker", &a)
+	}
+	require.Equal(t, breakerOpen, testBreakerState(c.breaker))
+	err = cache.Get(context.Background(), "testCircuitBreaker", &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return 2, nil
+	}))
+	require.Nil(t, err)
+	require.Equal(t, 2, a)
+}
+
+func testBreakerState(b *circuitBreaker) breakerState {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.state
+}
+
+// 测试用的metrics客户端, 记录计数器的值
+type testMetricsClient struct {
+	mx     sync.Mutex
+	values map[string]float64 // 指标名和标签 -> 值
+}
+
+func newTestMetricsClient() *testMetricsClient {
+	return &testMetricsClient{values: make(map[string]float64)}
+}
+
+func testMetricsKey(name string, labels metrics.Labels) string {
+	return fmt.Sprintf("%s{%s=%s,%s=%s,%s=%s}", name, labelCacheName, labels[labelCacheName],
+		labelOperation, labels[labelOperation], labelBackend, labels[labelBackend])
+}
+
+func (c *testMetricsClient) Value(name, cacheName, op, backend string) float64 {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.values[testMetricsKey(name, metrics.Labels{labelCacheName: cacheName, labelOperation: op, labelBackend: backend})]
+}
+
+func (c *testMetricsClient) add(name string, v float64, labels metrics.Labels) {
+	c.mx.Lock()
+	c.values[testMetricsKey(name, labels)] += v
+	c.mx.Unlock()
+}
+
+type testMetric struct {
+	c    *testMetricsClient
+	name string
+}
+
+func (m testMetric) Inc(labels metrics.Labels, exemplar metrics.Labels) { m.c.add(m.name, 1, labels) }
+func (m testMetric) Add(v float64, labels metrics.Labels, exemplar metrics.Labels) {
+	m.c.add(m.name, v, labels)
+}
+func (m testMetric) Observe(v float64, labels metrics.Labels, exemplar metrics.Labels) {
+	m.c.add(m.name, 1, labels)
+}
+
+type testGauge struct{ testMetric }
+
+func (g testGauge) Set(v float64, labels metrics.Labels)   { g.c.add(g.name, v, labels) }
+func (g testGauge) Inc(labels metrics.Labels)              { g.c.add(g.name, 1, labels) }
+func (g testGauge) Dec(labels metrics.Labels)              { g.c.add(g.name, -1, labels) }
+func (g testGauge) Add(v float64, labels metrics.Labels)   { g.c.add(g.name, v, labels) }
+func (g testGauge) Sub(v float64, labels metrics.Labels)   { g.c.add(g.name, -v, labels) }
+func (g testGauge) SetToCurrentTime(labels metrics.Labels) {}
+
+func (c *testMetricsClient) RegistryCounter(name, help string, constLabels metrics.Labels, labels ...string) metrics.ICounter {
+	return testMetric{c, name}
+}
+func (c *testMetricsClient) Counter(name string) metrics.ICounter { return testMetric{c, name} }
+func (c *testMetricsClient) RegistryGauge(name, help string, constLabels metrics.Labels, labels ...string) metrics.IGauge {
+	return testGauge{testMetric{c, name}}
+}
+func (c *testMetricsClient) Gauge(name string) metrics.IGauge { return testGauge{testMetric{c, name}} }
+func (c *testMetricsClient) RegistryHistogram(name, help string, buckets []float64, constLabels metrics.Labels, labels ...string) metrics.IHistogram {
+	return testMetric{c, name}
+}
+func (c *testMetricsClient) Histogram(name string) metrics.IHistogram { return testMetric{c, name} }
+func (c *testMetricsClient) RegistrySummary(name, help string, constLabels metrics.Labels, labels ...string) metrics.ISummary {
+	return testMetric{c, name}
+}
+func (c *testMetricsClient) Summary(name string) metrics.ISummary { return testMetric{c, name} }
+
+func TestMetrics(t *testing.T) {
+	// 在设置metrics客户端前创建cache
+	conf := NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.EnableMetrics = true
+	cache, err := NewCache("cachetest_metrics", conf)
+	require.Nil(t, err)
+
+	client := newTestMetricsClient()
+	old := metrics.GetClient()
+	metrics.SetClient(client)
+	defer metrics.SetClient(old)
+
+	ctx := context.Background()
+	const key = "testMetrics"
+	var a int
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, ErrCacheMiss, err)
+	err = cache.Get(ctx, key, &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return 1, nil
+	}))
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a)
+	require.Nil(t, err)
+	err = cache.Get(ctx, key+"_err", &a, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return nil, errors.New("load failed")
+	}))
+	require.NotNil(t, err)
+
+	require.Equal(t, float64(1), client.Value(metricsCacheHitTotal, "cachetest_metrics", opGet, "bigcache"))
+	require.Equal(t, float64(3), client.Value(metricsCacheMissTotal, "cachetest_metrics", opGet, "bigcache"))
+	require.Equal(t, float64(1), client.Value(metricsCacheErrTotal, "cachetest_metrics", opLoad, "bigcache"))
+	require.Equal(t, float64(2), client.Value(metricsCacheLoadMsec, "cachetest_metrics", opLoad, "bigcache"))
+	require.Equal(t, float64(0), client.Value(metricsCacheHitTotal, "cachetest_metrics", opGet, "redis"))
+
+	// 未启用指标时不上报
+	conf = NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.EnableMetrics = false
+	cache, err = NewCache("cachetest_metrics_disabled", conf)
+	require.Nil(t, err)
+	err = cache.Get(ctx, key, &a)
+	require.Equal(t, ErrCacheMiss, err)
+	require.Equal(t, float64(0), client.Value(metricsCacheMissTotal, "cachetest_metrics_disabled", opGet, "bigcache"))
+}
+
+// 测试用的链路追踪, 记录所有span的属性
+type testTracerProvider struct {
+	noop.TracerProvider
+	mx    sync.Mutex
+	spans []*testSpan
+}
+
+func (p *testTracerProvider) Tracer(name string, opts ...trace.TracerOption) trace.Tracer {
+	return testTracer{p: p}
+}
+
+func (p *testTracerProvider) Reset() {
+	p.mx.Lock()
+	p.spans = nil
+	p.mx.Unlock()
+}
+
+// 获取span的属性值, name 为空时匹配调用方的span(过滤器可能会创建新的span), 有多个时返回最后一个
+func (p *testTracerProvider) Attr(name, key string) string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	var v string
+	for _, span := range p.spans {
+		if span.name == name || (name == "" && !strings.HasPrefix(span.name, "cache/")) {
+			if a, ok := span.attrs[key]; ok {
+				v = a
+			}
+		}
+	}
+	return v
+}
+
+type testTracer struct {
+	noop.Tracer
+	p *testTracerProvider
+}
+
+func (t testTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	span := &testSpan{p: t.p, name: name, attrs: make(map[string]string)}
+	span.SetAttributes(cfg.Attributes()...)
+	t.p.mx.Lock()
+	t.p.spans = append(t.p.spans, span)
+	t.p.mx.Unlock()
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type testSpan struct {
+	noop.Span
+	p     *testTracerProvider
+	name  string
+	attrs map[string]string
+}
+
+func (s *testSpan) IsRecording() bool { return true }
+func (s *testSpan) SetAttributes(kv ...attribute.KeyValue) {
+	s.p.mx.Lock()
+	defer s.p.mx.Unlock()
+	for _, a := range kv {
+		s.attrs[string(a.Key)] = a.Value.Emit()
+	}
+}
+
+func TestTraceResult(t *testing.T) {
+	tp := &testTracerProvider{}
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(old)
+
+	conf := NewConfig()
+	conf.CacheDB.Type = "bigcache"
+	conf.StaleWriteProtect = true
+	cache, err := NewCache("cachetest_trace", conf)
+	require.Nil(t, err)
+
+	get := func(key string, opts ...core.Option) error {
+		tp.Reset()
+		ctx, span := tp.Tracer("").Start(context.Background(), "test")
+		defer span.End()
+		var a int
+		return cache.Get(ctx, key, &a, opts...)
+	}
+	load := WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return 1, nil
+	})
+
+	const key = "testTraceResult"
+	require.Nil(t, get(key, load))
+	require.Equal(t, traceResultMiss, tp.Attr("", traceAttrResult))
+	require.Nil(t, get(key))
+	require.Equal(t, traceResultHit, tp.Attr("", traceAttrResult))
+	require.Equal(t, "bigcache", tp.Attr("cache/db."+opGet, traceAttrBackend))
+	require.Equal(t, key, tp.Attr("cache/db."+opGet, traceAttrKey))
+
+	// 加载期间key被删除, 加载的span记录为stale
+	const staleKey = "testTraceResult_stale"
+	err = get(staleKey, WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		require.Nil(t, cache.Del(context.Background(), key))
+		return 1, nil
+	}))
+	require.Nil(t, err)
+	require.Equal(t, traceResultMiss, tp.Attr("", traceAttrResult))
+	require.Equal(t, traceResultStale, tp.Attr("cache/Load", traceAttrResult))
+}
+
+type testCountListener struct {
+	NoopListener
+	hit, miss, load, set, del int
+}
+
+func (l *testCountListener) OnHit(ctx context.Context, key string)               { l.hit++ }
+func (l *testCountListener) OnMiss(ctx context.Context, key string)              { l.miss++ }
+func (l *testCountListener) OnLoad(ctx context.Context, key string, err error)   { l.load++ }
+func (l *testCountListener) OnSet(ctx context.Context, key string, err error)    { l.set++ }
+func (l *testCountListener) OnDel(ctx context.Context, keys []string, err error) { l.del++ }
+
+func testListener(t *testing.T, cache ICache) {
+	const key = "testListener"
+
+	l := new(testCountListener)
+	var a = 3
+	var b int
+	err := cache.Get(context.Background(), key, &b, WithListener(l), WithLoadFn(func(ctx context.Context, key string) (interface{}, error) {
+		return a, nil
+	}))
+	require.Nil(t, err)
+	require.Equal(t, a, b)
+	require.Equal(t, 0, l.hit)
+	require.Equal(t, 1, l.miss)
+	require.Equal(t, 1, l.load)
+	require.Equal(t, 1, l.set)
+
+	var c int
+	err = cache.Get(context.Background(), key, &c, WithListener(l))
+	require.Nil(t, err)
+	require.Equal(t, a, c)
+	require.Equal(t, 1, l.hit)
+}
+
+func BenchmarkGet(b *testing.B) {
+	keyCount := []struct {
+		name   string
+		count  int
+		sizeMB int
+	}{
+		{"1k", 1000, 10},
+		//{"10k", 10000, 100},
+		//{"30k", 100000, 300},
+	}
+	compactors := []struct {
+		name string
+		core.ICompactor
+	}{
+		{"NoCompactor", NoCompactor},
+		//{"ZStdCompactor", ZStdCompactor},
+		//{"GzipCompactor", GzipCompactor},
+	}
+	serializers := []struct {
+		name string
+		core.ISerializer
+	}{
+		{"MsgPackSerializer", MsgPackSerializer},
+		//{"JsonIterStandardSerializer", JsonIterStandardSerializer},
+		//{"JsonSerializer", JsonSerializer},
+		//{"JsonIterSerializer", JsonIterSerializer},
+		//{"YamlSerializer", YamlSerializer},
+	}
+	for _, k := range keyCount {
+		for _, c := range compactors {
+			for _, s := range serializers {
+				name := fmt.Sprintf("%v_%v_%v", k.name, s.name, c.name)
+				b.Run(name, func(b *testing.B) {
+					benchGet(b, k.count, k.sizeMB, s, c)
+				})
+			}
+		}
+	}
+}
+
+func benchGet(b *testing.B, maxKeyCount, sizeMB int, serializer core.ISerializer, compactor core.ICompactor) {
+	rand.Seed(time.Now().UnixNano())
+	const dataLen = 512
+
+	conf := NewConfig()
+	conf.CacheDB.FreeCache.SizeMB = sizeMB
+	cache, err := NewCache("cachetest_benchGet", conf)
+	require.Nil(b, err)
+
+	expects := make([][]byte, maxKeyCount)
+	for i := 0; i < maxKeyCount; i++ {
+		bs := make([]byte, dataLen)
+		for j := 0; j < dataLen; j++ {
+			bs[j] = byte(rand.Int() & 255)
+		}
+		expects[i] = bs
+
+		key := strconv.Itoa(i)
+		err := cache.Set(context.Background(), key, bs, WithSerializer(serializer), WithCompactor(compactor))
+		require.NoError(b, err, "数据设置失败")
+	}
+
+	// 缓存随机key
+	randKeys := make([]int, 1<<20)
+	for i := 0; i < len(randKeys); i++ {
+		randKeys[i] = rand.Int() % maxKeyCount
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(p *testing.PB) {
+		i := 0
+		for p.Next() {
+			i++
+			key := randKeys[i&(len(randKeys)-1)]
+			expect := expects[key]
 
 			var bs []byte
 			err := cache.Get(context.Background(), strconv.Itoa(key), &bs, WithSerializer(serializer), WithCompactor(compactor))
@@ -358,3 +1758,193 @@ func benchGet(b *testing.B, maxKeyCount, sizeMB int, serializer core.ISerializer
 		}
 	})
 }
+
+func TestHedger(t *testing.T) {
+	conf := NewConfig()
+	conf.Hedge.Enable = true
+	conf.Hedge.MinDelayMs = 5
+	h := newHedger(conf)
+
+	// 样本不足时使用最小等待时间
+	for i := 1; i < hedgeMinSampleCount; i++ {
+		h.Observe(time.Duration(i) * time.Millisecond)
+	}
+	require.Equal(t, 5*time.Millisecond, h.Delay())
+
+	// 样本为 1..100ms, p95 为第96个样本
+	h.Observe(100 * time.Millisecond)
+	require.Equal(t, 96*time.Millisecond, h.Delay())
+
+	// 未到重新计算的样本数时p95不变
+	for i := 0; i < hedgeRefreshEvery-1; i++ {
+		h.Observe(time.Second)
+	}
+	require.Equal(t, 96*time.Millisecond, h.Delay())
+
+	// 样本写满后覆盖最旧的样本, p95 只根据保留的样本计算, 低于最小等待时间时使用最小等待时间
+	h = newHedger(conf)
+	for i := 0; i < hedgeSampleSize; i++ {
+		h.Observe(time.Second)
+	}
+	require.Equal(t, time.Second, h.Delay())
+	for i := 0; i < hedgeSampleSize; i++ {
+		h.Observe(time.Millisecond)
+	}
+	require.Equal(t, 5*time.Millisecond, h.Delay())
+	for i := 0; i < hedgeRefreshEvery; i++ {
+		h.Observe(50 * time.Millisecond)
+	}
+	require.Equal(t, 50*time.Millisecond, h.Delay())
+}
+
+func TestHedgedGet(t *testing.T) {
+	conf := NewConfig()
+	conf.Hedge.Enable = true
+	conf.Hedge.MinDelayMs = 20
+	cache, err := NewCache("cachetest_hedge", conf)
+	require.Nil(t, err)
+	c := cache.(*Cache)
+	db := newTestFakeCacheDB()
+	db.data["a"] = []byte("1")
+	c.cacheDB = db
+
+	t.Run("fast", func(t *testing.T) {
+		db.inject(nil, 0)
+		bs, err := c.dbGet(context.Background(), "a")
+		require.Nil(t, err)
+		require.Equal(t, "1", string(bs))
+		require.Equal(t, 1, db.Calls())
+	})
+	t.Run("slow", func(t *testing.T) {
+		db.inject(nil, 0, time.Second)
+		startTime := time.Now()
+		bs, err := c.dbGet(context.Background(), "a")
+		require.Nil(t, err)
+		require.Equal(t, "1", string(bs))
+		require.Equal(t, 2, db.Calls())
+		require.GreaterOrEqual(t, time.Since(startTime), 20*time.Millisecond)
+		require.Less(t, time.Since(startTime), time.Second)
+	})
+	dbErr := errors.New("db down")
+	t.Run("fail before hedge", func(t *testing.T) {
+		// 对冲前失败直接返回错误, 由重试策略处理
+		db.inject(dbErr, 0)
+		_, err := c.dbGet(context.Background(), "a")
+		require.Equal(t, dbErr, err)
+		require.Equal(t, 1, db.Calls())
+	})
+	t.Run("fail after hedge", func(t *testing.T) {
+		// 对冲后第一个请求失败时等待对冲请求的结果
+		db.inject(dbErr, 1, 30*time.Millisecond, 30*time.Millisecond)
+		bs, err := c.dbGet(context.Background(), "a")
+		require.Nil(t, err)
+		require.Equal(t, "1", string(bs))
+		require.Equal(t, 2, db.Calls())
+
+		db.inject(dbErr, 0, 30*time.Millisecond, 30*time.Millisecond)
+		_, err = c.dbGet(context.Background(), "a")
+		require.Equal(t, dbErr, err)
+		require.Equal(t, 2, db.Calls())
+	})
+}
+
+func TestRetry(t *testing.T) {
+	newCache := func(t *testing.T, conf *Config) (*Cache, *testFakeCacheDB) {
+		cache, err := NewCache("cachetest_retry", conf)
+		require.Nil(t, err)
+		c := cache.(*Cache)
+		db := newTestFakeCacheDB()
+		db.data["a"] = []byte("1")
+		c.cacheDB = db
+		return c, db
+	}
+	newConf := func() *Config {
+		conf := NewConfig()
+		conf.Retry.Attempts = 3
+		conf.Retry.BackoffMs = 20
+		conf.Retry.MaxBackoffMs = 30
+		conf.Retry.Jitter = 0
+		return conf
+	}
+	dbErr := errors.New("db down")
+
+	t.Run("backoff", func(t *testing.T) {
+		c, db := newCache(t, newConf())
+
+		// 等待 20ms + 30ms 后第三次成功, 第二次等待受最大等待时间限制
+		db.inject(dbErr, 2)
+		startTime := time.Now()
+		bs, err := c.dbGet(context.Background(), "a")
+		require.Nil(t, err)
+		require.Equal(t, "1", string(bs))
+		require.Equal(t, 3, db.Calls())
+		require.GreaterOrEqual(t, time.Since(startTime), 50*time.Millisecond)
+
+		db.inject(dbErr, 0)
+		_, err = c.dbGet(context.Background(), "a")
+		require.Equal(t, dbErr, err)
+		require.Equal(t, 3, db.Calls())
+	})
+	t.Run("not retryable", func(t *testing.T) {
+		conf := newConf()
+		conf.Retry.Retryable = func(err error) bool { return err != dbErr }
+		c, db := newCache(t, conf)
+
+		db.inject(ErrCacheMiss, 0)
+		_, err := c.dbGet(context.Background(), "a")
+		require.Equal(t, ErrCacheMiss, err)
+		require.Equal(t, 1, db.Calls())
+
+		db.inject(dbErr, 0)
+		_, err = c.dbGet(context.Background(), "a")
+		require.Equal(t, dbErr, err)
+		require.Equal(t, 1, db.Calls())
+	})
+	t.Run("jitter", func(t *testing.T) {
+		conf := newConf()
+		conf.Retry.Jitter = 0.2
+		c, _ := newCache(t, conf)
+
+		for _, v := range []struct {
+			random float64
+			want   time.Duration
+		}{
+			{0, 80 * time.Millisecond},
+			{0.5, 100 * time.Millisecond},
+			{0.75, 110 * time.Millisecond},
+		} {
+			c.retry.random = func() float64 { return v.random }
+			require.Equal(t, v.want, c.retry.wait(100*time.Millisecond))
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		// 每次尝试单独计算超时, 第一次超时后重试成功
+		conf := newConf()
+		conf.Timeout.GetMs = 20
+		c, db := newCache(t, conf)
+
+		db.inject(nil, 0, time.Second)
+		startTime := time.Now()
+		bs, err := c.dbGet(context.Background(), "a")
+		require.Nil(t, err)
+		require.Equal(t, "1", string(bs))
+		require.Equal(t, 2, db.Calls())
+		require.Less(t, time.Since(startTime), time.Second)
+	})
+	t.Run("deadline", func(t *testing.T) {
+		// 剩余时间不足以等待重试时直接返回
+		conf := newConf()
+		conf.Retry.BackoffMs = 100
+		conf.Retry.MaxBackoffMs = 100
+		c, db := newCache(t, conf)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		db.inject(dbErr, 0)
+		startTime := time.Now()
+		_, err := c.dbGet(ctx, "a")
+		require.Equal(t, dbErr, err)
+		require.Equal(t, 1, db.Calls())
+		require.Less(t, time.Since(startTime), 50*time.Millisecond)
+	})
+}
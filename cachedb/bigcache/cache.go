@@ -1,51 +1,209 @@
 package bigcache
 
 import (
+	"bytes"
 	"context"
+	"strings"
 	"time"
 
 	"github.com/allegro/bigcache/v3"
 
+	"github.com/zly-app/cache/v2/cachedb/internal/expirehdr"
 	"github.com/zly-app/cache/v2/core"
 	"github.com/zly-app/cache/v2/errs"
 )
 
+var _ core.IEvictNotifier = (*bigCache)(nil)
+var _ core.IScanCacheDB = (*bigCache)(nil)
+var _ core.IExpireCacheDB = (*bigCache)(nil)
+var _ core.ISlidingCacheDB = (*bigCache)(nil)
+var _ core.ICompareAndSetCacheDB = (*bigCache)(nil)
+
 type bigCache struct {
 	cache       *bigcache.BigCache
 	exactExpire bool
+	lifeWindow  time.Duration // 数据的最大有效期, 为0表示永不过期
+	onEvict     core.EvictCallback
+	locks       expirehdr.KeyLocks
 }
 
-func (m *bigCache) Get(ctx context.Context, key string) ([]byte, error) {
+// 获取带头部的数据
+func (m *bigCache) getEntry(key string) ([]byte, error) {
+	var entry []byte
+	var err error
 	if !m.exactExpire {
-		data, err := m.cache.Get(key)
-		if err == bigcache.ErrEntryNotFound {
-			return nil, errs.CacheMiss
+		entry, err = m.cache.Get(key)
+	} else {
+		var info bigcache.Response
+		entry, info, err = m.cache.GetWithInfo(key)
+		if err == nil && info.EntryStatus != 0 {
+			err = bigcache.ErrEntryNotFound
 		}
-		return data, err
 	}
-	data, info, err := m.cache.GetWithInfo(key)
-	if err == bigcache.ErrEntryNotFound || info.EntryStatus != 0 {
+	if err == bigcache.ErrEntryNotFound {
 		return nil, errs.CacheMiss
 	}
-	return data, err
+	if err != nil {
+		return nil, err
+	}
+
+	if expirehdr.Expired(entry) {
+		return nil, errs.CacheMiss
+	}
+	return entry, nil
+}
+
+func (m *bigCache) Get(ctx context.Context, key string) ([]byte, error) {
+	entry, err := m.getEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	return entry[expirehdr.HeaderSize:], nil
 }
 
-func (m *bigCache) Set(ctx context.Context, key string, data []byte, expireSec int) error {
-	return m.cache.Set(key, data)
+func (m *bigCache) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	mx := m.locks.Get(key)
+	mx.Lock()
+	defer mx.Unlock()
+	return m.cache.Set(key, m.wrapEntry(data, ttl))
+}
+
+func (m *bigCache) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := m.getEntry(key)
+	if err == errs.CacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *bigCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	entry, err := m.getEntry(key)
+	if err != nil {
+		return 0, err
+	}
+	expireAt, _ := expirehdr.ReadExpireAt(entry)
+	if expireAt.IsZero() {
+		return core.NoExpire, nil
+	}
+	return time.Until(expireAt), nil
+}
+
+// bigcache 不支持单独修改有效期, 通过重新写入实现
+func (m *bigCache) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := m.GetAndTouch(ctx, key, ttl)
+	return err
+}
+
+// 通过重新写入重置有效期, 读取和写入期间持有key的写入锁
+func (m *bigCache) GetAndTouch(ctx context.Context, key string, ttl time.Duration) ([]byte, error) {
+	mx := m.locks.Get(key)
+	mx.Lock()
+	defer mx.Unlock()
+
+	entry, err := m.getEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	data := entry[expirehdr.HeaderSize:]
+	err = m.cache.Set(key, m.wrapEntry(data, ttl))
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *bigCache) CompareAndSet(ctx context.Context, key string, old, data []byte, ttl time.Duration) (bool, error) {
+	mx := m.locks.Get(key)
+	mx.Lock()
+	defer mx.Unlock()
+
+	entry, err := m.getEntry(key)
+	if err == errs.CacheMiss {
+		return false, nil
+	}
+	if err != nil || !bytes.Equal(entry[expirehdr.HeaderSize:], old) {
+		return false, err
+	}
+	return true, m.cache.Set(key, m.wrapEntry(data, ttl))
 }
 
 func (m *bigCache) Del(ctx context.Context, keys ...string) error {
 	for _, key := range keys {
+		mx := m.locks.Get(key)
+		mx.Lock()
 		_ = m.cache.Delete(key)
+		mx.Unlock()
+	}
+	return nil
+}
+
+func (m *bigCache) Scan(ctx context.Context, prefix string, fn func(key string) bool) error {
+	it := m.cache.Iterator()
+	for it.SetNext() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		entry, err := it.Value()
+		if err != nil { // 遍历期间被删除
+			continue
+		}
+		if strings.HasPrefix(entry.Key(), prefix) && !fn(entry.Key()) {
+			return nil
+		}
 	}
 	return nil
 }
 
+func (m *bigCache) DelPrefix(ctx context.Context, prefix string) error {
+	var keys []string
+	err := m.Scan(ctx, prefix, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return m.Del(ctx, keys...)
+}
+
+func (m *bigCache) Clear(ctx context.Context) error {
+	return m.cache.Reset()
+}
+
+// 为数据加上头部, 有效期不会超过 lifeWindow
+func (m *bigCache) wrapEntry(data []byte, ttl time.Duration) []byte {
+	life := m.lifeWindow
+	if ttl > 0 && (life == 0 || ttl < life) {
+		life = ttl
+	}
+	return expirehdr.Wrap(data, life)
+}
+
+func (m *bigCache) SetEvictCallback(fn core.EvictCallback) {
+	m.onEvict = fn
+}
+
+func (m *bigCache) onRemove(key string, entry []byte, reason bigcache.RemoveReason) {
+	if m.onEvict == nil {
+		return
+	}
+	switch reason {
+	case bigcache.Expired:
+		m.onEvict(key, core.EvictReasonExpired)
+	case bigcache.NoSpace:
+		m.onEvict(key, core.EvictReasonNoSpace)
+	}
+}
+
 func (m *bigCache) Close() error {
 	return m.cache.Close()
 }
 
 func NewCache(shards, expireSec, cleanTimeMs, maxEntriesInWindow, maxEntrySize, hardMaxCacheSize int, exactExpire bool) (core.ICacheDB, error) {
+	var lifeWindow time.Duration
+	if expireSec > 0 {
+		lifeWindow = time.Duration(expireSec) * time.Second
+	}
 	if expireSec <= 0 {
 		expireSec = 31536000000 // 1000年
 	}
@@ -57,9 +215,13 @@ func NewCache(shards, expireSec, cleanTimeMs, maxEntriesInWindow, maxEntrySize,
 		MaxEntrySize:       maxEntrySize,
 		HardMaxCacheSize:   hardMaxCacheSize,
 	}
-	cache, err := bigcache.New(context.Background(), conf)
-	return &bigCache{
-		cache:       cache,
+	m := &bigCache{
 		exactExpire: exactExpire,
-	}, err
+		lifeWindow:  lifeWindow,
+	}
+	conf.OnRemoveWithReason = m.onRemove
+	conf = conf.OnRemoveFilterSet(bigcache.Expired, bigcache.NoSpace)
+	cache, err := bigcache.New(context.Background(), conf)
+	m.cache = cache
+	return m, err
 }
@@ -0,0 +1,674 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zly-app/zapp/logger"
+	"go.uber.org/zap"
+
+	"github.com/zly-app/cache/v2/core"
+	"github.com/zly-app/cache/v2/errs"
+)
+
+// 健康检查使用的key, 只会被读取
+const probeKey = "_zly_cache_fallback_probe_"
+
+// 备用缓存数据库中最多记录的脏key数量
+const maxDirtyKeys = 100000
+
+// 恢复时删除脏数据的最大轮数, 每轮删除上一轮期间的变更
+const recoverRounds = 3
+
+var _ core.ICacheDB = (*fallbackCache)(nil)
+var _ core.IScanCacheDB = (*fallbackCache)(nil)
+var _ core.IExpireCacheDB = (*fallbackCache)(nil)
+var _ core.ISlidingCacheDB = (*fallbackCache)(nil)
+var _ core.ICompareAndSetCacheDB = (*fallbackCache)(nil)
+var _ core.IVersionedCacheDB = (*fallbackCache)(nil)
+var _ core.ITaggedCacheDB = (*fallbackCache)(nil)
+var _ core.IEvictNotifier = (*fallbackCache)(nil)
+var _ core.IExpireAtCacheDB = (*fallbackCache)(nil)
+var _ core.IVersionedExpireAtCacheDB = (*fallbackCache)(nil)
+var _ core.IWrappedCacheDB = (*fallbackCache)(nil)
+
+var (
+	// 缓存数据库不支持遍历
+	errScanNotSupported = errors.New("缓存数据库不支持遍历")
+	// 缓存数据库不支持过期时间操作
+	errExpireNotSupported = errors.New("缓存数据库不支持过期时间操作")
+	// 缓存数据库不支持比较后写入
+	errCASNotSupported = errors.New("缓存数据库不支持比较后写入")
+	// 缓存数据库不支持版本
+	errVersionNotSupported = errors.New("缓存数据库不支持版本")
+	// 缓存数据库不支持标签
+	errTagNotSupported = errors.New("缓存数据库不支持标签")
+)
+
+// 备用缓存数据库建造者
+type Creator func() (core.ICacheDB, error)
+
+type fallbackCache struct {
+	primary       core.ICacheDB
+	creator       Creator
+	checkInterval time.Duration
+	failThreshold int32
+	failCount     int32 // 主缓存数据库连续失败次数
+
+	mx         sync.RWMutex
+	fallback   core.ICacheDB      // 不为nil表示正在使用备用缓存数据库
+	generation int                // 切换到备用缓存数据库的次数, 用于区分每次备用期间的版本
+	evictFn    core.EvictCallback // 数据被淘汰时的回调, 同时设置到主缓存数据库和备用缓存数据库
+
+	dirtyMx       sync.Mutex
+	dirty         *dirtySet                      // 使用备用缓存数据库期间变更过的数据
+	localTags     map[string]map[string]struct{} // 备用缓存数据库不支持标签时在本地记录的标签成员
+	localTagCount int
+
+	versionMx sync.Mutex
+	versions  [versionSlots]uint64 // 备用缓存数据库不支持版本时在本地记录的版本
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+/*
+创建一个带备用缓存数据库的ICacheDB
+
+	primary 主缓存数据库
+	creator 备用缓存数据库建造者, 在主缓存数据库连续失败 failThreshold 次后创建并切换到备用缓存数据库
+	checkIntervalSec 使用备用缓存数据库期间对主缓存数据库进行健康检查的间隔秒数
+
+主缓存数据库恢复后, 会先删除使用备用缓存数据库期间写入或删除的key, 然后切换回主缓存数据库并清空备用缓存数据库.
+主缓存数据库支持的版本和标签接口会被转发, 备用缓存数据库不支持时在本地维护
+*/
+func NewCache(primary core.ICacheDB, creator Creator, checkIntervalSec, failThreshold int) core.ICacheDB {
+	if checkIntervalSec < 1 {
+		checkIntervalSec = 1
+	}
+	if failThreshold < 1 {
+		failThreshold = 1
+	}
+	return &fallbackCache{
+		primary:       primary,
+		creator:       creator,
+		checkInterval: time.Duration(checkIntervalSec) * time.Second,
+		failThreshold: int32(failThreshold),
+		closeChan:     make(chan struct{}),
+	}
+}
+
+// 使用备用缓存数据库期间变更过的数据, 恢复时会从主缓存数据库删除
+type dirtySet struct {
+	keys     map[string]struct{} // 写入或删除的key
+	prefixes map[string]struct{} // 按前缀删除的前缀
+	tags     map[string]struct{} // 获取过成员的标签, 删除标签下的key
+	clear    bool                // 清空过, 清空主缓存数据库
+	overflow bool                // 变更的key数量超出记录上限
+}
+
+func newDirtySet() *dirtySet {
+	return &dirtySet{
+		keys:     make(map[string]struct{}),
+		prefixes: make(map[string]struct{}),
+		tags:     make(map[string]struct{}),
+	}
+}
+
+func (d *dirtySet) empty() bool {
+	return len(d.keys) == 0 && len(d.prefixes) == 0 && len(d.tags) == 0 && !d.clear
+}
+
+// 合并另一组变更, 用于恢复失败时放回取出的变更
+func (d *dirtySet) merge(o *dirtySet) {
+	for key := range o.keys {
+		if len(d.keys) >= maxDirtyKeys {
+			d.overflow = true
+			break
+		}
+		d.keys[key] = struct{}{}
+	}
+	for prefix := range o.prefixes {
+		d.prefixes[prefix] = struct{}{}
+	}
+	for tag := range o.tags {
+		d.tags[tag] = struct{}{}
+	}
+	d.clear = d.clear || o.clear
+	d.overflow = d.overflow || o.overflow
+}
+
+// wrapper自身产生的错误, 不是主缓存数据库故障
+var wrapperErrs = []error{errScanNotSupported, errExpireNotSupported, errCASNotSupported, errVersionNotSupported, errTagNotSupported}
+
+// 是否为主缓存数据库故障, 调用方的ctx已取消或超时和wrapper自身产生的错误不视为故障
+func isFault(ctx context.Context, err error) bool {
+	if err == nil || err == errs.CacheMiss || ctx.Err() != nil {
+		return false
+	}
+	for _, e := range wrapperErrs {
+		if err == e {
+			return false
+		}
+	}
+	return true
+}
+
+// 报告主缓存数据库的结果, 返回是否已切换到备用缓存数据库
+func (f *fallbackCache) report(ctx context.Context, err error) bool {
+	if err == nil || err == errs.CacheMiss {
+		atomic.StoreInt32(&f.failCount, 0)
+		return false
+	}
+	if !isFault(ctx, err) {
+		return false
+	}
+	if atomic.AddInt32(&f.failCount, 1) < f.failThreshold {
+		return false
+	}
+	return f.switchToFallback(err)
+}
+
+func (f *fallbackCache) switchToFallback(cause error) bool {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if f.fallback != nil {
+		return true
+	}
+	select {
+	case <-f.closeChan:
+		return false
+	default:
+	}
+
+	db, err := f.creator()
+	if err != nil {
+		logger.Log.Error("创建备用缓存数据库失败", zap.Error(err))
+		return false
+	}
+	f.fallback = db
+	f.generation++
+	f.dirty = newDirtySet()
+	f.localTags = make(map[string]map[string]struct{})
+	f.localTagCount = 0
+	if n, ok := db.(core.IEvictNotifier); ok && f.evictFn != nil {
+		n.SetEvictCallback(f.evictFn)
+	}
+	logger.Log.Warn("主缓存数据库故障, 切换到备用缓存数据库", zap.Error(cause))
+
+	go f.checkLoop()
+	return true
+}
+
+// 检查主缓存数据库是否恢复
+func (f *fallbackCache) checkLoop() {
+	t := time.NewTicker(f.checkInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-f.closeChan:
+			return
+		case <-t.C:
+			if f.tryRecover() {
+				return
+			}
+		}
+	}
+}
+
+/*
+主缓存数据库恢复后删除备用期间变更过的数据并切换回主缓存数据库.
+
+	删除时不持有锁, 期间仍然使用备用缓存数据库, 删除期间新的变更在下一轮删除. 最后一轮的变更在切换后删除, 期间可能短暂读到旧数据
+*/
+func (f *fallbackCache) tryRecover() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), f.checkInterval)
+	defer cancel()
+
+	_, err := f.primary.Get(ctx, probeKey)
+	if err != nil && err != errs.CacheMiss {
+		return false
+	}
+
+	for i := 0; i < recoverRounds; i++ {
+		dirty := f.takeDirty()
+		if dirty == nil {
+			return true // 已关闭
+		}
+		if dirty.empty() {
+			break
+		}
+		err = f.cleanPrimary(ctx, dirty)
+		if err != nil {
+			f.putBackDirty(dirty)
+			logger.Log.Warn("主缓存数据库删除脏数据失败, 继续使用备用缓存数据库", zap.Error(err))
+			return false
+		}
+	}
+
+	f.mx.Lock()
+	db, dirty := f.fallback, f.dirty
+	f.fallback = nil
+	f.dirty = nil
+	f.localTags = nil
+	f.localTagCount = 0
+	atomic.StoreInt32(&f.failCount, 0)
+	f.mx.Unlock()
+	if db == nil {
+		return true // 已关闭
+	}
+	_ = db.Close()
+
+	if !dirty.empty() {
+		err = f.cleanPrimary(ctx, dirty)
+		if err != nil {
+			logger.Log.Warn("切换后删除主缓存数据库的脏数据失败, 主缓存数据库中可能存在旧数据", zap.Error(err))
+		}
+	}
+	logger.Log.Info("主缓存数据库已恢复, 切换回主缓存数据库")
+	return true
+}
+
+// 取出备用期间的变更并换成新的记录, 已切换回主缓存数据库时返回nil
+func (f *fallbackCache) takeDirty() *dirtySet {
+	f.dirtyMx.Lock()
+	defer f.dirtyMx.Unlock()
+	dirty := f.dirty
+	if dirty != nil {
+		f.dirty = newDirtySet()
+	}
+	return dirty
+}
+
+// 放回取出的变更
+func (f *fallbackCache) putBackDirty(dirty *dirtySet) {
+	f.dirtyMx.Lock()
+	defer f.dirtyMx.Unlock()
+	if f.dirty != nil {
+		f.dirty.merge(dirty)
+	}
+}
+
+// 从主缓存数据库删除变更过的数据, 避免主缓存数据库返回旧数据
+func (f *fallbackCache) cleanPrimary(ctx context.Context, dirty *dirtySet) error {
+	if s, ok := f.primary.(core.IScanCacheDB); ok && dirty.clear {
+		if err := s.Clear(ctx); err != nil {
+			return err
+		}
+	}
+	if len(dirty.keys) > 0 {
+		keys := make([]string, 0, len(dirty.keys))
+		for key := range dirty.keys {
+			keys = append(keys, key)
+		}
+		if err := f.delPrimary(ctx, keys...); err != nil {
+			return err
+		}
+	}
+	for tag := range dirty.tags {
+		if err := f.delPrimaryTag(ctx, tag); err != nil {
+			return err
+		}
+	}
+	if s, ok := f.primary.(core.IScanCacheDB); ok {
+		for prefix := range dirty.prefixes {
+			if err := s.DelPrefix(ctx, prefix); err != nil {
+				return err
+			}
+		}
+	}
+	if dirty.overflow {
+		logger.Log.Warn("备用期间变更的key数量超出记录上限, 主缓存数据库中可能存在旧数据", zap.Int("max", maxDirtyKeys))
+	}
+	return nil
+}
+
+// 删除主缓存数据库中的key, 主缓存数据库支持版本时同时更新版本, 避免恢复前开始的加载写入旧数据
+func (f *fallbackCache) delPrimary(ctx context.Context, keys ...string) error {
+	if v, ok := f.primary.(core.IVersionedCacheDB); ok {
+		return v.DelAndBumpVersion(ctx, keys...)
+	}
+	return f.primary.Del(ctx, keys...)
+}
+
+// 删除主缓存数据库中标签下的key
+func (f *fallbackCache) delPrimaryTag(ctx context.Context, tag string) error {
+	t, ok := f.primary.(core.ITaggedCacheDB)
+	if !ok {
+		return nil
+	}
+	keys, err := t.TagMembers(ctx, tag)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	err = f.delPrimary(ctx, keys...)
+	if err != nil {
+		return err
+	}
+	return t.RemoveFromTag(ctx, tag, keys...)
+}
+
+// 获取正在使用的备用缓存数据库, 返回nil表示使用主缓存数据库. 返回不为nil时必须调用 f.mx.RUnlock
+func (f *fallbackCache) rLockFallback() core.ICacheDB {
+	f.mx.RLock()
+	if f.fallback == nil {
+		f.mx.RUnlock()
+		return nil
+	}
+	return f.fallback
+}
+
+// 标记脏key, 必须持有读锁
+func (f *fallbackCache) markDirty(keys ...string) {
+	f.dirtyMx.Lock()
+	defer f.dirtyMx.Unlock()
+	for _, key := range keys {
+		if len(f.dirty.keys) >= maxDirtyKeys {
+			f.dirty.overflow = true
+			return
+		}
+		f.dirty.keys[key] = struct{}{}
+	}
+}
+
+// 标记按前缀删除的前缀, 必须持有读锁
+func (f *fallbackCache) markDirtyPrefix(prefix string) {
+	f.dirtyMx.Lock()
+	defer f.dirtyMx.Unlock()
+	f.dirty.prefixes[prefix] = struct{}{}
+}
+
+// 标记已清空, 必须持有读锁
+func (f *fallbackCache) markDirtyClear() {
+	f.dirtyMx.Lock()
+	defer f.dirtyMx.Unlock()
+	f.dirty.clear = true
+}
+
+func (f *fallbackCache) Get(ctx context.Context, key string) ([]byte, error) {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		return db.Get(ctx, key)
+	}
+
+	bs, err := f.primary.Get(ctx, key)
+	if f.report(ctx, err) {
+		return f.Get(ctx, key)
+	}
+	return bs, err
+}
+
+func (f *fallbackCache) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		f.markDirty(key)
+		return db.Set(ctx, key, data, ttl)
+	}
+
+	err := f.primary.Set(ctx, key, data, ttl)
+	if f.report(ctx, err) {
+		return f.Set(ctx, key, data, ttl)
+	}
+	return err
+}
+
+func (f *fallbackCache) SetAt(ctx context.Context, key string, data []byte, expireAt time.Time) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		f.markDirty(key)
+		return setAt(ctx, db, key, data, expireAt)
+	}
+
+	err := setAt(ctx, f.primary, key, data, expireAt)
+	if f.report(ctx, err) {
+		return f.SetAt(ctx, key, data, expireAt)
+	}
+	return err
+}
+
+// 按截止时间写入, 缓存数据库不支持时转换为有效期写入, 截止时间已过时删除key
+func setAt(ctx context.Context, db core.ICacheDB, key string, data []byte, expireAt time.Time) error {
+	if s, ok := db.(core.IExpireAtCacheDB); ok {
+		return s.SetAt(ctx, key, data, expireAt)
+	}
+	ttl := time.Until(expireAt)
+	if ttl <= 0 {
+		return db.Del(ctx, key)
+	}
+	return db.Set(ctx, key, data, ttl)
+}
+
+func (f *fallbackCache) Del(ctx context.Context, keys ...string) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		f.markDirty(keys...)
+		return db.Del(ctx, keys...)
+	}
+
+	err := f.primary.Del(ctx, keys...)
+	if f.report(ctx, err) {
+		return f.Del(ctx, keys...)
+	}
+	return err
+}
+
+func (f *fallbackCache) Exists(ctx context.Context, key string) (bool, error) {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		e, ok := db.(core.IExpireCacheDB)
+		if !ok {
+			return false, errExpireNotSupported
+		}
+		return e.Exists(ctx, key)
+	}
+
+	e, ok := f.primary.(core.IExpireCacheDB)
+	if !ok {
+		return false, errExpireNotSupported
+	}
+	exists, err := e.Exists(ctx, key)
+	if f.report(ctx, err) {
+		return f.Exists(ctx, key)
+	}
+	return exists, err
+}
+
+func (f *fallbackCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		e, ok := db.(core.IExpireCacheDB)
+		if !ok {
+			return 0, errExpireNotSupported
+		}
+		return e.TTL(ctx, key)
+	}
+
+	e, ok := f.primary.(core.IExpireCacheDB)
+	if !ok {
+		return 0, errExpireNotSupported
+	}
+	ttl, err := e.TTL(ctx, key)
+	if f.report(ctx, err) {
+		return f.TTL(ctx, key)
+	}
+	return ttl, err
+}
+
+func (f *fallbackCache) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		e, ok := db.(core.IExpireCacheDB)
+		if !ok {
+			return errExpireNotSupported
+		}
+		f.markDirty(key)
+		return e.Touch(ctx, key, ttl)
+	}
+
+	e, ok := f.primary.(core.IExpireCacheDB)
+	if !ok {
+		return errExpireNotSupported
+	}
+	err := e.Touch(ctx, key, ttl)
+	if f.report(ctx, err) {
+		return f.Touch(ctx, key, ttl)
+	}
+	return err
+}
+
+func (f *fallbackCache) GetAndTouch(ctx context.Context, key string, ttl time.Duration) ([]byte, error) {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		f.markDirty(key)
+		return getAndTouch(ctx, db, key, ttl)
+	}
+
+	bs, err := getAndTouch(ctx, f.primary, key, ttl)
+	if f.report(ctx, err) {
+		return f.GetAndTouch(ctx, key, ttl)
+	}
+	return bs, err
+}
+
+// 获取数据并重置有效期, 缓存数据库不支持滑动过期时使用 Get 和 Touch 实现
+func getAndTouch(ctx context.Context, db core.ICacheDB, key string, ttl time.Duration) ([]byte, error) {
+	if s, ok := db.(core.ISlidingCacheDB); ok {
+		return s.GetAndTouch(ctx, key, ttl)
+	}
+	e, ok := db.(core.IExpireCacheDB)
+	if !ok {
+		return nil, errExpireNotSupported
+	}
+	bs, err := db.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	err = e.Touch(ctx, key, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return bs, nil
+}
+
+func (f *fallbackCache) CompareAndSet(ctx context.Context, key string, old, data []byte, ttl time.Duration) (bool, error) {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		s, ok := db.(core.ICompareAndSetCacheDB)
+		if !ok {
+			return false, errCASNotSupported
+		}
+		f.markDirty(key)
+		return s.CompareAndSet(ctx, key, old, data, ttl)
+	}
+
+	s, ok := f.primary.(core.ICompareAndSetCacheDB)
+	if !ok {
+		return false, errCASNotSupported
+	}
+	ok, err := s.CompareAndSet(ctx, key, old, data, ttl)
+	if f.report(ctx, err) {
+		return f.CompareAndSet(ctx, key, old, data, ttl)
+	}
+	return ok, err
+}
+
+func (f *fallbackCache) Scan(ctx context.Context, prefix string, fn func(key string) bool) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		s, ok := db.(core.IScanCacheDB)
+		if !ok {
+			return errScanNotSupported
+		}
+		return s.Scan(ctx, prefix, fn)
+	}
+
+	s, ok := f.primary.(core.IScanCacheDB)
+	if !ok {
+		return errScanNotSupported
+	}
+	err := s.Scan(ctx, prefix, fn)
+	if f.report(ctx, err) {
+		return f.Scan(ctx, prefix, fn)
+	}
+	return err
+}
+
+func (f *fallbackCache) DelPrefix(ctx context.Context, prefix string) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		s, ok := db.(core.IScanCacheDB)
+		if !ok {
+			return errScanNotSupported
+		}
+		f.markDirtyPrefix(prefix)
+		return s.DelPrefix(ctx, prefix)
+	}
+
+	s, ok := f.primary.(core.IScanCacheDB)
+	if !ok {
+		return errScanNotSupported
+	}
+	err := s.DelPrefix(ctx, prefix)
+	if f.report(ctx, err) {
+		return f.DelPrefix(ctx, prefix)
+	}
+	return err
+}
+
+func (f *fallbackCache) Clear(ctx context.Context) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		s, ok := db.(core.IScanCacheDB)
+		if !ok {
+			return errScanNotSupported
+		}
+		f.markDirtyClear()
+		return s.Clear(ctx)
+	}
+
+	s, ok := f.primary.(core.IScanCacheDB)
+	if !ok {
+		return errScanNotSupported
+	}
+	err := s.Clear(ctx)
+	if f.report(ctx, err) {
+		return f.Clear(ctx)
+	}
+	return err
+}
+
+func (f *fallbackCache) SetEvictCallback(fn core.EvictCallback) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	f.evictFn = fn
+	if n, ok := f.primary.(core.IEvictNotifier); ok {
+		n.SetEvictCallback(fn)
+	}
+	if n, ok := f.fallback.(core.IEvictNotifier); ok {
+		n.SetEvictCallback(fn)
+	}
+}
+
+// 返回主缓存数据库, 可选接口是否可用以主缓存数据库为准
+func (f *fallbackCache) Unwrap() core.ICacheDB {
+	return f.primary
+}
+
+func (f *fallbackCache) Close() error {
+	f.closeOnce.Do(func() { close(f.closeChan) })
+
+	f.mx.Lock()
+	db := f.fallback
+	f.fallback = nil
+	f.mx.Unlock()
+
+	if db != nil {
+		_ = db.Close()
+	}
+	return f.primary.Close()
+}
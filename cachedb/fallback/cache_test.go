@@ -0,0 +1,478 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/zly-app/cache/v2/core"
+	"github.com/zly-app/cache/v2/errs"
+)
+
+var errDown = errors.New("db down")
+
+// 测试用的内存缓存数据库
+type testMemDB struct {
+	mx    sync.Mutex
+	data  map[string][]byte
+	down  bool
+	evict core.EvictCallback
+}
+
+func newTestMemDB() *testMemDB {
+	return &testMemDB{data: make(map[string][]byte)}
+}
+
+func (m *testMemDB) setDown(down bool) {
+	m.mx.Lock()
+	m.down = down
+	m.mx.Unlock()
+}
+
+func (m *testMemDB) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.down {
+		return nil, errDown
+	}
+	bs, ok := m.data[key]
+	if !ok {
+		return nil, errs.CacheMiss
+	}
+	return bs, nil
+}
+
+func (m *testMemDB) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.down {
+		return errDown
+	}
+	m.data[key] = data
+	return nil
+}
+
+func (m *testMemDB) Del(ctx context.Context, keys ...string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.down {
+		return errDown
+	}
+	for _, key := range keys {
+		delete(m.data, key)
+	}
+	return nil
+}
+
+func (m *testMemDB) Close() error { return nil }
+
+func (m *testMemDB) SetEvictCallback(fn core.EvictCallback) {
+	m.mx.Lock()
+	m.evict = fn
+	m.mx.Unlock()
+}
+
+// 测试用的支持版本和标签的内存缓存数据库
+type testVersionedMemDB struct {
+	*testMemDB
+	versions map[string]int
+	tags     map[string]map[string]struct{}
+}
+
+func newTestVersionedMemDB() *testVersionedMemDB {
+	return &testVersionedMemDB{
+		testMemDB: newTestMemDB(),
+		versions:  make(map[string]int),
+		tags:      make(map[string]map[string]struct{}),
+	}
+}
+
+func (m *testVersionedMemDB) GetVersion(ctx context.Context, key string) (string, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.down {
+		return "", errDown
+	}
+	return strconv.Itoa(m.versions[key]), nil
+}
+
+func (m *testVersionedMemDB) SetIfVersion(ctx context.Context, key string, data []byte, ttl time.Duration, version string) (bool, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.down {
+		return false, errDown
+	}
+	if strconv.Itoa(m.versions[key]) != version {
+		return false, nil
+	}
+	m.data[key] = data
+	return true, nil
+}
+
+func (m *testVersionedMemDB) DelAndBumpVersion(ctx context.Context, keys ...string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.down {
+		return errDown
+	}
+	for _, key := range keys {
+		delete(m.data, key)
+		m.versions[key]++
+	}
+	return nil
+}
+
+func (m *testVersionedMemDB) AddTags(ctx context.Context, key string, ttl time.Duration, tags ...string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.down {
+		return errDown
+	}
+	for _, tag := range tags {
+		if m.tags[tag] == nil {
+			m.tags[tag] = make(map[string]struct{})
+		}
+		m.tags[tag][key] = struct{}{}
+	}
+	return nil
+}
+
+func (m *testVersionedMemDB) TagMembers(ctx context.Context, tag string) ([]string, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.down {
+		return nil, errDown
+	}
+	keys := make([]string, 0, len(m.tags[tag]))
+	for key := range m.tags[tag] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *testVersionedMemDB) RemoveFromTag(ctx context.Context, tag string, keys ...string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.down {
+		return errDown
+	}
+	for _, key := range keys {
+		delete(m.tags[tag], key)
+	}
+	return nil
+}
+
+func newTestFallback(primary core.ICacheDB) *fallbackCache {
+	return NewCache(primary, func() (core.ICacheDB, error) {
+		return newTestMemDB(), nil
+	}, 1, 2).(*fallbackCache)
+}
+
+func (f *fallbackCache) isFallback() bool {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	return f.fallback != nil
+}
+
+func TestSwitchToFallback(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestVersionedMemDB()
+	f := newTestFallback(primary)
+	defer f.Close()
+
+	require.Nil(t, f.Set(ctx, "a", []byte("1"), 0))
+	primary.setDown(true)
+
+	// 未达到失败次数时返回主缓存数据库的错误
+	_, err := f.Get(ctx, "a")
+	require.Equal(t, errDown, err)
+	require.False(t, f.isFallback())
+
+	// 达到失败次数后切换到备用缓存数据库
+	_, err = f.Get(ctx, "a")
+	require.Equal(t, errs.CacheMiss, err)
+	require.True(t, f.isFallback())
+
+	require.Nil(t, f.Set(ctx, "b", []byte("2"), 0))
+	bs, err := f.Get(ctx, "b")
+	require.Nil(t, err)
+	require.Equal(t, []byte("2"), bs)
+
+	// 主缓存数据库未恢复时继续使用备用缓存数据库
+	require.False(t, f.tryRecover())
+	require.True(t, f.isFallback())
+}
+
+func TestFallbackRecover(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestVersionedMemDB()
+	f := newTestFallback(primary)
+	defer f.Close()
+
+	require.Nil(t, f.Set(ctx, "a", []byte("1"), 0))
+	require.Nil(t, f.Set(ctx, "b", []byte("1"), 0))
+	require.Nil(t, f.Set(ctx, "c", []byte("1"), 0))
+	require.Nil(t, f.AddTags(ctx, "c", 0, "tag"))
+	primary.setDown(true)
+	require.True(t, f.switchToFallback(errDown))
+
+	// 备用期间的写入, 删除和按标签删除
+	require.Nil(t, f.Set(ctx, "a", []byte("2"), 0))
+	require.Nil(t, f.DelAndBumpVersion(ctx, "b"))
+	keys, err := f.TagMembers(ctx, "tag")
+	require.Nil(t, err)
+	require.Empty(t, keys)
+
+	// 主缓存数据库恢复后, 备用期间变更过的key从主缓存数据库删除并更新版本
+	primary.setDown(false)
+	require.True(t, f.tryRecover())
+	require.False(t, f.isFallback())
+	for _, key := range []string{"a", "b", "c"} {
+		_, err = f.Get(ctx, key)
+		require.Equal(t, errs.CacheMiss, err, key)
+		require.Equal(t, 1, primary.versions[key], key)
+	}
+	keys, err = f.TagMembers(ctx, "tag")
+	require.Nil(t, err)
+	require.Empty(t, keys)
+}
+
+func TestFallbackCheckLoop(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestVersionedMemDB()
+	f := newTestFallback(primary)
+	defer f.Close()
+
+	primary.setDown(true)
+	require.True(t, f.switchToFallback(errDown))
+	require.Nil(t, f.Set(ctx, "a", []byte("1"), 0))
+
+	time.Sleep(f.checkInterval + 200*time.Millisecond)
+	require.True(t, f.isFallback())
+
+	// 健康检查发现主缓存数据库恢复后自动切换回去
+	primary.setDown(false)
+	require.Eventually(t, func() bool { return !f.isFallback() }, 3*f.checkInterval, 100*time.Millisecond)
+	_, err := f.Get(ctx, "a")
+	require.Equal(t, errs.CacheMiss, err)
+}
+
+func TestFallbackLocalVersion(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestVersionedMemDB()
+	f := newTestFallback(primary)
+	defer f.Close()
+
+	// 切换前获取的版本不能写入备用缓存数据库
+	version, err := f.GetVersion(ctx, "a")
+	require.Nil(t, err)
+	primary.setDown(true)
+	require.True(t, f.switchToFallback(errDown))
+	ok, err := f.SetIfVersion(ctx, "a", []byte("1"), 0, version)
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	// 备用期间版本未改变时写入, 删除后旧版本无法写入
+	version, err = f.GetVersion(ctx, "a")
+	require.Nil(t, err)
+	ok, err = f.SetIfVersion(ctx, "a", []byte("1"), 0, version)
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.Nil(t, f.DelAndBumpVersion(ctx, "a"))
+	ok, err = f.SetIfVersion(ctx, "a", []byte("2"), 0, version)
+	require.Nil(t, err)
+	require.False(t, ok)
+	_, err = f.Get(ctx, "a")
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 备用期间按截止时间按版本写入, 截止时间已过时删除key
+	version, err = f.GetVersion(ctx, "a")
+	require.Nil(t, err)
+	ok, err = f.SetAtIfVersion(ctx, "a", []byte("3"), time.Now().Add(time.Minute), version)
+	require.Nil(t, err)
+	require.True(t, ok)
+	bs, err := f.Get(ctx, "a")
+	require.Nil(t, err)
+	require.Equal(t, "3", string(bs))
+	ok, err = f.SetAtIfVersion(ctx, "a", []byte("4"), time.Now().Add(-time.Second), version)
+	require.Nil(t, err)
+	require.True(t, ok)
+	_, err = f.Get(ctx, "a")
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 备用期间本地维护标签
+	require.Nil(t, f.AddTags(ctx, "b", 0, "tag"))
+	keys, err := f.TagMembers(ctx, "tag")
+	require.Nil(t, err)
+	require.Equal(t, []string{"b"}, keys)
+	require.Nil(t, f.RemoveFromTag(ctx, "tag", "b"))
+	keys, err = f.TagMembers(ctx, "tag")
+	require.Nil(t, err)
+	require.Empty(t, keys)
+}
+
+func TestFallbackEvictCallback(t *testing.T) {
+	primary := newTestMemDB()
+	f := newTestFallback(primary)
+	defer f.Close()
+
+	var evicted []string
+	f.SetEvictCallback(func(key string, reason core.EvictReason) { evicted = append(evicted, key) })
+	primary.evict("a", core.EvictReasonExpired)
+
+	primary.setDown(true)
+	require.True(t, f.switchToFallback(errDown))
+	f.mx.RLock()
+	db := f.fallback.(*testMemDB)
+	f.mx.RUnlock()
+	db.evict("b", core.EvictReasonExpired)
+	require.Equal(t, []string{"a", "b"}, evicted)
+
+	// 主缓存数据库不支持的可选接口返回错误
+	_, err := f.GetVersion(context.Background(), "a")
+	require.Nil(t, err) // 备用期间使用本地版本
+	primary.setDown(false)
+	require.True(t, f.tryRecover())
+	_, err = f.GetVersion(context.Background(), "a")
+	require.Equal(t, errVersionNotSupported, err)
+}
+
+func TestFallbackSetAt(t *testing.T) {
+	ctx := context.Background()
+	primary := newTestMemDB()
+	f := newTestFallback(primary)
+	defer f.Close()
+
+	// 主缓存数据库不支持按截止时间写入时转换为有效期写入, 截止时间已过时删除key
+	require.Nil(t, f.SetAt(ctx, "a", []byte("1"), time.Now().Add(time.Minute)))
+	bs, err := f.Get(ctx, "a")
+	require.Nil(t, err)
+	require.Equal(t, "1", string(bs))
+	require.Nil(t, f.SetAt(ctx, "a", []byte("2"), time.Now().Add(-time.Second)))
+	_, err = f.Get(ctx, "a")
+	require.Equal(t, errs.CacheMiss, err)
+
+	// 备用期间写入备用缓存数据库并标记为脏数据
+	primary.setDown(true)
+	require.True(t, f.switchToFallback(errDown))
+	require.Nil(t, f.SetAt(ctx, "b", []byte("1"), time.Now().Add(time.Minute)))
+	bs, err = f.Get(ctx, "b")
+	require.Nil(t, err)
+	require.Equal(t, "1", string(bs))
+	_, err = primary.Get(ctx, "b")
+	require.Equal(t, errDown, err)
+}
+
+// 测试用的删除会阻塞的缓存数据库, 用于检查恢复时是否持有锁
+type testBlockingDelDB struct {
+	*testVersionedMemDB
+	deleting chan struct{}
+	unblock  chan struct{}
+	delErr   error
+}
+
+func (m *testBlockingDelDB) DelAndBumpVersion(ctx context.Context, keys ...string) error {
+	select {
+	case m.deleting <- struct{}{}:
+	default:
+	}
+	<-m.unblock
+	if m.delErr != nil {
+		return m.delErr
+	}
+	return m.testVersionedMemDB.DelAndBumpVersion(ctx, keys...)
+}
+
+func TestFallbackRecoverWithoutLock(t *testing.T) {
+	ctx := context.Background()
+	primary := &testBlockingDelDB{
+		testVersionedMemDB: newTestVersionedMemDB(),
+		deleting:           make(chan struct{}, 1),
+		unblock:            make(chan struct{}),
+	}
+	f := newTestFallback(primary)
+	defer f.Close()
+
+	require.True(t, f.switchToFallback(errDown))
+	require.Nil(t, f.Set(ctx, "a", []byte("1"), 0))
+
+	recovered := make(chan bool, 1)
+	go func() { recovered <- f.tryRecover() }()
+	<-primary.deleting
+
+	// 删除主缓存数据库的脏数据期间仍然可以读写备用缓存数据库
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.Nil(t, f.Set(ctx, "b", []byte("1"), 0))
+		bs, err := f.Get(ctx, "a")
+		require.Nil(t, err)
+		require.Equal(t, "1", string(bs))
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("恢复期间读写被阻塞")
+	}
+
+	// 删除期间变更的key在下一轮删除
+	close(primary.unblock)
+	require.True(t, <-recovered)
+	require.False(t, f.isFallback())
+	require.Equal(t, 1, primary.versions["a"])
+	require.Equal(t, 1, primary.versions["b"])
+}
+
+func TestFallbackRecoverFail(t *testing.T) {
+	ctx := context.Background()
+	primary := &testBlockingDelDB{
+		testVersionedMemDB: newTestVersionedMemDB(),
+		deleting:           make(chan struct{}, 1),
+		unblock:            make(chan struct{}),
+		delErr:             errDown,
+	}
+	close(primary.unblock)
+	f := newTestFallback(primary)
+	defer f.Close()
+
+	require.True(t, f.switchToFallback(errDown))
+	require.Nil(t, f.Set(ctx, "a", []byte("1"), 0))
+
+	// 删除失败时继续使用备用缓存数据库, 取出的脏key会被放回
+	require.False(t, f.tryRecover())
+	require.True(t, f.isFallback())
+	primary.delErr = nil
+	require.True(t, f.tryRecover())
+	require.Equal(t, 1, primary.versions["a"])
+}
+
+func TestFallbackIgnoreCallerErr(t *testing.T) {
+	primary := newTestMemDB()
+	f := newTestFallback(primary)
+	defer f.Close()
+
+	// 调用方取消的请求不视为主缓存数据库故障
+	primary.setDown(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 3; i++ {
+		_, err := f.Get(ctx, "a")
+		require.Equal(t, errDown, err)
+	}
+	require.False(t, f.isFallback())
+
+	// wrapper自身产生的错误不视为主缓存数据库故障
+	primary.setDown(false)
+	for i := 0; i < 3; i++ {
+		_, err := f.GetAndTouch(context.Background(), "a", time.Second)
+		require.Equal(t, errExpireNotSupported, err)
+	}
+	require.False(t, f.isFallback())
+}
@@ -0,0 +1,128 @@
+package fallback
+
+import (
+	"context"
+	"time"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+// 在本地记录标签成员, 必须持有读锁. 不记录有效期, 获取成员时可能包含已过期的key
+func (f *fallbackCache) addLocalTags(key string, tags ...string) {
+	f.dirtyMx.Lock()
+	defer f.dirtyMx.Unlock()
+	for _, tag := range tags {
+		keys, ok := f.localTags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			f.localTags[tag] = keys
+		}
+		if _, ok := keys[key]; ok {
+			continue
+		}
+		if f.localTagCount >= maxDirtyKeys {
+			f.dirty.overflow = true
+			return
+		}
+		keys[key] = struct{}{}
+		f.localTagCount++
+	}
+}
+
+// 获取本地记录的标签成员, 必须持有读锁
+func (f *fallbackCache) localTagMembers(tag string) []string {
+	f.dirtyMx.Lock()
+	defer f.dirtyMx.Unlock()
+	keys := make([]string, 0, len(f.localTags[tag]))
+	for key := range f.localTags[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// 从本地记录的标签中移除key, 必须持有读锁
+func (f *fallbackCache) removeLocalTag(tag string, keys ...string) {
+	f.dirtyMx.Lock()
+	defer f.dirtyMx.Unlock()
+	members := f.localTags[tag]
+	for _, key := range keys {
+		if _, ok := members[key]; ok {
+			delete(members, key)
+			f.localTagCount--
+		}
+	}
+	if len(members) == 0 {
+		delete(f.localTags, tag)
+	}
+}
+
+// 标记获取过成员的标签, 必须持有读锁
+func (f *fallbackCache) markDirtyTag(tag string) {
+	f.dirtyMx.Lock()
+	defer f.dirtyMx.Unlock()
+	f.dirty.tags[tag] = struct{}{}
+}
+
+func (f *fallbackCache) AddTags(ctx context.Context, key string, ttl time.Duration, tags ...string) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		if t, ok := db.(core.ITaggedCacheDB); ok {
+			return t.AddTags(ctx, key, ttl, tags...)
+		}
+		f.addLocalTags(key, tags...)
+		return nil
+	}
+
+	t, ok := f.primary.(core.ITaggedCacheDB)
+	if !ok {
+		return errTagNotSupported
+	}
+	err := t.AddTags(ctx, key, ttl, tags...)
+	if f.report(ctx, err) {
+		return f.AddTags(ctx, key, ttl, tags...)
+	}
+	return err
+}
+
+// 备用期间获取的成员不包含备用前加入标签的key, 这些key会在恢复时从主缓存数据库删除
+func (f *fallbackCache) TagMembers(ctx context.Context, tag string) ([]string, error) {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		f.markDirtyTag(tag)
+		if t, ok := db.(core.ITaggedCacheDB); ok {
+			return t.TagMembers(ctx, tag)
+		}
+		return f.localTagMembers(tag), nil
+	}
+
+	t, ok := f.primary.(core.ITaggedCacheDB)
+	if !ok {
+		return nil, errTagNotSupported
+	}
+	keys, err := t.TagMembers(ctx, tag)
+	if f.report(ctx, err) {
+		return f.TagMembers(ctx, tag)
+	}
+	return keys, err
+}
+
+func (f *fallbackCache) RemoveFromTag(ctx context.Context, tag string, keys ...string) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		if t, ok := db.(core.ITaggedCacheDB); ok {
+			return t.RemoveFromTag(ctx, tag, keys...)
+		}
+		f.removeLocalTag(tag, keys...)
+		return nil
+	}
+
+	t, ok := f.primary.(core.ITaggedCacheDB)
+	if !ok {
+		return errTagNotSupported
+	}
+	err := t.RemoveFromTag(ctx, tag, keys...)
+	if f.report(ctx, err) {
+		return f.RemoveFromTag(ctx, tag, keys...)
+	}
+	return err
+}
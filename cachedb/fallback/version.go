@@ -0,0 +1,136 @@
+package fallback
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+// 本地版本的槽数, key按哈希分槽, 同一个槽中的key更新版本时其它key正在进行的写入也会被跳过
+const versionSlots = 4096
+
+func versionSlot(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % versionSlots)
+}
+
+// 获取备用期间的本地版本, 必须持有读锁和 f.versionMx
+func (f *fallbackCache) localVersion(key string) string {
+	return "fallback:" + strconv.Itoa(f.generation) + ":" + strconv.FormatUint(f.versions[versionSlot(key)], 10)
+}
+
+func (f *fallbackCache) GetVersion(ctx context.Context, key string) (string, error) {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		if v, ok := db.(core.IVersionedCacheDB); ok {
+			return v.GetVersion(ctx, key)
+		}
+		f.versionMx.Lock()
+		defer f.versionMx.Unlock()
+		return f.localVersion(key), nil
+	}
+
+	v, ok := f.primary.(core.IVersionedCacheDB)
+	if !ok {
+		return "", errVersionNotSupported
+	}
+	version, err := v.GetVersion(ctx, key)
+	if f.report(ctx, err) {
+		return f.GetVersion(ctx, key)
+	}
+	return version, err
+}
+
+func (f *fallbackCache) SetIfVersion(ctx context.Context, key string, data []byte, ttl time.Duration, version string) (bool, error) {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		f.markDirty(key)
+		if v, ok := db.(core.IVersionedCacheDB); ok {
+			return v.SetIfVersion(ctx, key, data, ttl, version)
+		}
+		f.versionMx.Lock()
+		defer f.versionMx.Unlock()
+		if version != f.localVersion(key) {
+			return false, nil
+		}
+		err := db.Set(ctx, key, data, ttl)
+		return err == nil, err
+	}
+
+	v, ok := f.primary.(core.IVersionedCacheDB)
+	if !ok {
+		return false, errVersionNotSupported
+	}
+	ok, err := v.SetIfVersion(ctx, key, data, ttl, version)
+	if f.report(ctx, err) {
+		return f.SetIfVersion(ctx, key, data, ttl, version)
+	}
+	return ok, err
+}
+
+// 备用缓存数据库只支持按有效期按版本写入时转换为有效期, 截止时间已过时不写入
+func (f *fallbackCache) SetAtIfVersion(ctx context.Context, key string, data []byte, expireAt time.Time, version string) (bool, error) {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		f.markDirty(key)
+		if v, ok := db.(core.IVersionedExpireAtCacheDB); ok {
+			return v.SetAtIfVersion(ctx, key, data, expireAt, version)
+		}
+		if v, ok := db.(core.IVersionedCacheDB); ok {
+			ttl := time.Until(expireAt)
+			if ttl <= 0 {
+				return false, nil
+			}
+			return v.SetIfVersion(ctx, key, data, ttl, version)
+		}
+		f.versionMx.Lock()
+		defer f.versionMx.Unlock()
+		if version != f.localVersion(key) {
+			return false, nil
+		}
+		err := setAt(ctx, db, key, data, expireAt)
+		return err == nil, err
+	}
+
+	v, ok := f.primary.(core.IVersionedExpireAtCacheDB)
+	if !ok {
+		return false, errVersionNotSupported
+	}
+	ok, err := v.SetAtIfVersion(ctx, key, data, expireAt, version)
+	if f.report(ctx, err) {
+		return f.SetAtIfVersion(ctx, key, data, expireAt, version)
+	}
+	return ok, err
+}
+
+func (f *fallbackCache) DelAndBumpVersion(ctx context.Context, keys ...string) error {
+	if db := f.rLockFallback(); db != nil {
+		defer f.mx.RUnlock()
+		f.markDirty(keys...)
+		if v, ok := db.(core.IVersionedCacheDB); ok {
+			return v.DelAndBumpVersion(ctx, keys...)
+		}
+		f.versionMx.Lock()
+		defer f.versionMx.Unlock()
+		for _, key := range keys {
+			f.versions[versionSlot(key)]++
+		}
+		return db.Del(ctx, keys...)
+	}
+
+	v, ok := f.primary.(core.IVersionedCacheDB)
+	if !ok {
+		return errVersionNotSupported
+	}
+	err := v.DelAndBumpVersion(ctx, keys...)
+	if f.report(ctx, err) {
+		return f.DelAndBumpVersion(ctx, keys...)
+	}
+	return err
+}
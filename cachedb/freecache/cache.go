@@ -1,10 +1,14 @@
 package freecache
 
 import (
+	"bytes"
 	"context"
+	"strings"
+	"time"
 
 	"github.com/coocood/freecache"
 
+	"github.com/zly-app/cache/v2/cachedb/internal/expirehdr"
 	"github.com/zly-app/cache/v2/core"
 	"github.com/zly-app/cache/v2/errs"
 )
@@ -12,26 +16,162 @@ import (
 // 最小内存大小
 const minMemoryMB = 1
 
+var _ core.IScanCacheDB = (*freeCache)(nil)
+var _ core.IExpireCacheDB = (*freeCache)(nil)
+var _ core.ISlidingCacheDB = (*freeCache)(nil)
+var _ core.ICompareAndSetCacheDB = (*freeCache)(nil)
+
+/*
+基于 freecache 的本地缓存数据库.
+
+	不支持淘汰通知(core.IEvictNotifier), freecache 没有提供淘汰回调, 空间不足时被覆盖的数据和过期的数据都在内部直接丢弃,
+	只能通过 EvacuateCount 和 ExpiredCount 获取数量, 无法得知被淘汰的key
+*/
 type freeCache struct {
 	cache *freecache.Cache
+	locks expirehdr.KeyLocks
 }
 
-func (m *freeCache) Get(ctx context.Context, key string) ([]byte, error) {
-	data, err := m.cache.Get([]byte(key))
+// 获取带头部的数据
+func (m *freeCache) getEntry(key string) ([]byte, error) {
+	entry, err := m.cache.Get([]byte(key))
 	if err == freecache.ErrNotFound {
 		return nil, errs.CacheMiss
 	}
-	return data, err
+	if err != nil {
+		return nil, err
+	}
+
+	if expirehdr.Expired(entry) {
+		return nil, errs.CacheMiss
+	}
+	return entry, nil
+}
+
+func (m *freeCache) Get(ctx context.Context, key string) ([]byte, error) {
+	entry, err := m.getEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	return entry[expirehdr.HeaderSize:], nil
 }
 
-func (m *freeCache) Set(ctx context.Context, key string, data []byte, expireSec int) error {
-	return m.cache.Set([]byte(key), data, expireSec)
+func (m *freeCache) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	mx := m.locks.Get(key)
+	mx.Lock()
+	defer mx.Unlock()
+	return m.set(key, data, ttl)
+}
+
+func (m *freeCache) set(key string, data []byte, ttl time.Duration) error {
+	return m.cache.Set([]byte(key), expirehdr.Wrap(data, ttl), expireSeconds(ttl))
+}
+
+// 转换为 freecache 的有效期秒数, 向上取整. freecache 的有效期只精确到秒, 精确的过期时间由数据头部控制
+func expireSeconds(ttl time.Duration) int {
+	if ttl <= 0 {
+		return 0
+	}
+	return int((ttl + time.Second - 1) / time.Second)
 }
 
 func (m *freeCache) Del(ctx context.Context, keys ...string) error {
 	for _, key := range keys {
+		mx := m.locks.Get(key)
+		mx.Lock()
 		_ = m.cache.Del([]byte(key))
+		mx.Unlock()
+	}
+	return nil
+}
+
+func (m *freeCache) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := m.getEntry(key)
+	if err == errs.CacheMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *freeCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	entry, err := m.getEntry(key)
+	if err != nil {
+		return 0, err
 	}
+	expireAt, _ := expirehdr.ReadExpireAt(entry)
+	if expireAt.IsZero() {
+		return core.NoExpire, nil
+	}
+	return time.Until(expireAt), nil
+}
+
+// 过期时间保存在数据头部, 通过重新写入实现
+func (m *freeCache) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := m.GetAndTouch(ctx, key, ttl)
+	return err
+}
+
+// 通过重新写入重置有效期, 读取和写入期间持有key的写入锁
+func (m *freeCache) GetAndTouch(ctx context.Context, key string, ttl time.Duration) ([]byte, error) {
+	mx := m.locks.Get(key)
+	mx.Lock()
+	defer mx.Unlock()
+
+	entry, err := m.getEntry(key)
+	if err != nil {
+		return nil, err
+	}
+	data := entry[expirehdr.HeaderSize:]
+	err = m.set(key, data, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (m *freeCache) CompareAndSet(ctx context.Context, key string, old, data []byte, ttl time.Duration) (bool, error) {
+	mx := m.locks.Get(key)
+	mx.Lock()
+	defer mx.Unlock()
+
+	entry, err := m.getEntry(key)
+	if err == errs.CacheMiss {
+		return false, nil
+	}
+	if err != nil || !bytes.Equal(entry[expirehdr.HeaderSize:], old) {
+		return false, err
+	}
+	return true, m.set(key, data, ttl)
+}
+
+func (m *freeCache) Scan(ctx context.Context, prefix string, fn func(key string) bool) error {
+	it := m.cache.NewIterator()
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key := string(entry.Key)
+		if strings.HasPrefix(key, prefix) && !fn(key) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *freeCache) DelPrefix(ctx context.Context, prefix string) error {
+	var keys []string
+	err := m.Scan(ctx, prefix, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return m.Del(ctx, keys...)
+}
+
+func (m *freeCache) Clear(ctx context.Context) error {
+	m.cache.Clear()
 	return nil
 }
 
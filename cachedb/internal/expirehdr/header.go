@@ -0,0 +1,37 @@
+package expirehdr
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// 本地缓存数据库的数据头部长度, 头部保存过期时间的毫秒时间戳, 为0表示永不过期
+const HeaderSize = 8
+
+// 为数据加上头部, ttl <= 0 时表示永不过期
+func Wrap(data []byte, ttl time.Duration) []byte {
+	entry := make([]byte, HeaderSize+len(data))
+	if ttl > 0 {
+		binary.LittleEndian.PutUint64(entry, uint64(time.Now().Add(ttl).UnixMilli()))
+	}
+	copy(entry[HeaderSize:], data)
+	return entry
+}
+
+// 读取数据头部的过期时间, 数据长度不足头部长度时返回 false
+func ReadExpireAt(entry []byte) (time.Time, bool) {
+	if len(entry) < HeaderSize {
+		return time.Time{}, false
+	}
+	ms := int64(binary.LittleEndian.Uint64(entry))
+	if ms == 0 {
+		return time.Time{}, true
+	}
+	return time.UnixMilli(ms), true
+}
+
+// 数据是否已过期, 头部不完整的数据视为已过期
+func Expired(entry []byte) bool {
+	expireAt, ok := ReadExpireAt(entry)
+	return !ok || (!expireAt.IsZero() && !time.Now().Before(expireAt))
+}
@@ -0,0 +1,37 @@
+package expirehdr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeader(t *testing.T) {
+	entry := Wrap([]byte("a"), 0)
+	require.Equal(t, []byte("a"), entry[HeaderSize:])
+	expireAt, ok := ReadExpireAt(entry)
+	require.True(t, ok)
+	require.True(t, expireAt.IsZero())
+	require.False(t, Expired(entry))
+
+	entry = Wrap([]byte("a"), time.Minute)
+	expireAt, ok = ReadExpireAt(entry)
+	require.True(t, ok)
+	require.WithinDuration(t, time.Now().Add(time.Minute), expireAt, time.Second)
+	require.False(t, Expired(entry))
+
+	entry = Wrap([]byte("a"), time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	require.True(t, Expired(entry))
+
+	// 头部不完整
+	_, ok = ReadExpireAt([]byte("a"))
+	require.False(t, ok)
+	require.True(t, Expired([]byte("a")))
+}
+
+func TestKeyLocks(t *testing.T) {
+	var l KeyLocks
+	require.Same(t, l.Get("a"), l.Get("a"))
+}
@@ -0,0 +1,19 @@
+package expirehdr
+
+import "sync"
+
+// 分段锁的数量
+const lockShards = 256
+
+// 按key分段的写入锁, 读取后重新写入期间持有锁, 避免覆盖并发写入或删除的数据
+type KeyLocks [lockShards]sync.Mutex
+
+// 获取key所在分段的锁
+func (l *KeyLocks) Get(key string) *sync.Mutex {
+	h := uint32(2166136261) // fnv-1a
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return &l[h%lockShards]
+}
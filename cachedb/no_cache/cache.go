@@ -2,12 +2,16 @@ package no_cache
 
 import (
 	"context"
+	"time"
 
 	"github.com/zly-app/cache/v2/core"
 	"github.com/zly-app/cache/v2/errs"
 )
 
 var _ core.ICacheDB = (*noCache)(nil)
+var _ core.IScanCacheDB = (*noCache)(nil)
+var _ core.IExpireCacheDB = (*noCache)(nil)
+var _ core.ISlidingCacheDB = (*noCache)(nil)
 
 type noCache struct{}
 
@@ -18,7 +22,7 @@ func (n noCache) Get(ctx context.Context, key string) ([]byte, error) {
 	return nil, errs.CacheMiss
 }
 
-func (n noCache) Set(ctx context.Context, key string, data []byte, expireSec int) error {
+func (n noCache) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
 	return nil
 }
 
@@ -26,6 +30,34 @@ func (n noCache) Del(ctx context.Context, keys ...string) error {
 	return nil
 }
 
+func (n noCache) Exists(ctx context.Context, key string) (bool, error) {
+	return false, nil
+}
+
+func (n noCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return 0, errs.CacheMiss
+}
+
+func (n noCache) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	return errs.CacheMiss
+}
+
+func (n noCache) GetAndTouch(ctx context.Context, key string, ttl time.Duration) ([]byte, error) {
+	return nil, errs.CacheMiss
+}
+
+func (n noCache) Scan(ctx context.Context, prefix string, fn func(key string) bool) error {
+	return nil
+}
+
+func (n noCache) DelPrefix(ctx context.Context, prefix string) error {
+	return nil
+}
+
+func (n noCache) Clear(ctx context.Context) error {
+	return nil
+}
+
 func (n noCache) Close() error {
 	return nil
 }
@@ -25,13 +25,8 @@ func (r *redisCache) Get(ctx context.Context, key string) ([]byte, error) {
 	return nil, err
 }
 
-func (r *redisCache) Set(ctx context.Context, key string, data []byte, expireSec int) error {
-	var ex time.Duration
-	if expireSec > 0 {
-		ex = time.Duration(expireSec) * time.Second
-	}
-
-	return r.client.Set(ctx, key, data, ex).Err()
+func (r *redisCache) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return r.client.Set(ctx, key, data, expiration(ttl)).Err()
 }
 
 func (r *redisCache) Del(ctx context.Context, keys ...string) error {
@@ -46,6 +41,22 @@ func (r *redisCache) Close() error {
 	return r.client.Close()
 }
 
+// 转换为 go-redis 的有效期参数, 精确到毫秒, 不足1毫秒按1毫秒处理. 返回0表示永不过期
+func expiration(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return 0
+	}
+	if ttl < time.Millisecond {
+		return time.Millisecond
+	}
+	return ttl.Truncate(time.Millisecond)
+}
+
+// 转换为 PX 参数, 返回0表示永不过期
+func px(ttl time.Duration) int64 {
+	return expiration(ttl).Milliseconds()
+}
+
 func NewRedisCache(client redis.UniversalClient) core.ICacheDB {
 	return &redisCache{client: client}
 }
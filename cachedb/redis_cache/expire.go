@@ -0,0 +1,96 @@
+package redis_cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/zly-app/component/redis"
+
+	"github.com/zly-app/cache/v2/core"
+	"github.com/zly-app/cache/v2/errs"
+)
+
+var _ core.IExpireCacheDB = (*redisCache)(nil)
+var _ core.ISlidingCacheDB = (*redisCache)(nil)
+var _ core.ICompareAndSetCacheDB = (*redisCache)(nil)
+var _ core.IExpireAtCacheDB = (*redisCache)(nil)
+
+var compareAndSetScript = newScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
+// 写入后使用 PEXPIREAT 设置截止时间, 截止时间已过时redis会删除key
+var setAtScript = newScript(`
+redis.call('SET', KEYS[1], ARGV[1])
+redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+return 1
+`)
+
+func (r *redisCache) Exists(ctx context.Context, key string) (bool, error) {
+	n, err := r.client.Exists(ctx, key).Result()
+	return n > 0, err
+}
+
+func (r *redisCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := r.client.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	switch ttl {
+	case -2: // key不存在
+		return 0, errs.CacheMiss
+	case -1: // 永不过期
+		return core.NoExpire, nil
+	}
+	return ttl, nil
+}
+
+func (r *redisCache) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	if ttl > 0 {
+		ok, err := r.client.PExpire(ctx, key, expiration(ttl)).Result()
+		if err == nil && !ok {
+			return errs.CacheMiss
+		}
+		return err
+	}
+
+	// PERSIST 在key不存在和key本来就永不过期时都返回false, 需要再检查key是否存在
+	ok, err := r.client.Persist(ctx, key).Result()
+	if err != nil || ok {
+		return err
+	}
+	exists, err := r.Exists(ctx, key)
+	if err == nil && !exists {
+		return errs.CacheMiss
+	}
+	return err
+}
+
+// 使用 GETEX 在获取时重置有效期, 要求 redis >= 6.2.0
+func (r *redisCache) GetAndTouch(ctx context.Context, key string, ttl time.Duration) ([]byte, error) {
+	s, err := r.client.GetEx(ctx, key, expiration(ttl)).Result()
+	if err == nil {
+		return []byte(s), nil
+	}
+	if err == redis.Nil {
+		return nil, errs.CacheMiss
+	}
+	return nil, err
+}
+
+func (r *redisCache) SetAt(ctx context.Context, key string, data []byte, expireAt time.Time) error {
+	return setAtScript.Run(ctx, r.client, []string{key}, data, expireAt.UnixMilli()).Err()
+}
+
+func (r *redisCache) CompareAndSet(ctx context.Context, key string, old, data []byte, ttl time.Duration) (bool, error) {
+	n, err := compareAndSetScript.Run(ctx, r.client, []string{key}, old, data, px(ttl)).Int()
+	return n == 1, err
+}
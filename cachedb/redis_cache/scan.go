@@ -0,0 +1,134 @@
+package redis_cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/zly-app/component/redis"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+var _ core.IScanCacheDB = (*redisCache)(nil)
+var _ core.IClearGuardCacheDB = (*redisCache)(nil)
+
+// 每次SCAN的数量
+const scanCount = 1000
+
+// 用于提前结束遍历
+var errStopScan = errors.New("stop scan")
+
+// 没有key前缀时清空会删除共用redis的其它服务的数据, 所以不允许
+var ErrClearWithoutPrefix = errors.New("redis不允许清空整个数据库, 请设置KeyPrefix")
+
+// 转义glob模式中的特殊字符
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// 是否为内部使用的key
+func isInternalKey(key string) bool {
+	return strings.HasSuffix(key, versionKeySuffix)
+}
+
+// 对每个节点执行fn, 集群模式下会并发遍历所有主节点
+func (r *redisCache) forEachNode(ctx context.Context, fn func(ctx context.Context, c redis.Cmdable) error) error {
+	if cc, ok := r.client.(*goredis.ClusterClient); ok {
+		return cc.ForEachMaster(ctx, func(ctx context.Context, c *goredis.Client) error {
+			return fn(ctx, c)
+		})
+	}
+	return fn(ctx, r.client)
+}
+
+// 分批遍历节点中匹配前缀的key
+func scanNode(ctx context.Context, c redis.Cmdable, prefix string, fn func(keys []string) error) error {
+	match := escapePattern(prefix) + "*"
+	var cursor uint64
+	for {
+		keys, next, err := c.Scan(ctx, cursor, match, scanCount).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err = fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (r *redisCache) Scan(ctx context.Context, prefix string, fn func(key string) bool) error {
+	var mx sync.Mutex // 集群模式下并发遍历, 保证fn不会被并发调用
+	stopped := false
+	err := r.forEachNode(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		return scanNode(ctx, c, prefix, func(keys []string) error {
+			mx.Lock()
+			defer mx.Unlock()
+			for _, key := range keys {
+				if stopped {
+					return errStopScan
+				}
+				if !isInternalKey(key) && !fn(key) {
+					stopped = true
+				}
+			}
+			return nil
+		})
+	})
+	if err == errStopScan {
+		return nil
+	}
+	return err
+}
+
+/*
+删除前缀为prefix的所有key.
+
+	版本key不会被删除, 删除后版本会回到初始值, 使删除前开始的加载可以写入旧数据. 版本key都有有效期, 不会一直残留
+*/
+func (r *redisCache) DelPrefix(ctx context.Context, prefix string) error {
+	if prefix == "" {
+		return ErrClearWithoutPrefix
+	}
+	return r.forEachNode(ctx, func(ctx context.Context, c redis.Cmdable) error {
+		return scanNode(ctx, c, prefix, func(keys []string) error {
+			// 集群模式下多个key可能不在同一个slot, 所以逐个UNLINK
+			pipe := c.Pipeline()
+			for _, key := range keys {
+				if !isInternalKey(key) {
+					pipe.Unlink(ctx, key)
+				}
+			}
+			if pipe.Len() == 0 {
+				return nil
+			}
+			_, err := pipe.Exec(ctx)
+			return err
+		})
+	})
+}
+
+func (r *redisCache) Clear(ctx context.Context) error {
+	return ErrClearWithoutPrefix
+}
+
+// redis可能被多个服务共用, 不允许清空整个数据库
+func (r *redisCache) CheckClear() error {
+	return ErrClearWithoutPrefix
+}
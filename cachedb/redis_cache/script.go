@@ -0,0 +1,32 @@
+package redis_cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+
+	"github.com/zly-app/component/redis"
+)
+
+// lua脚本, 优先使用 EVALSHA 执行
+type script struct {
+	src  string
+	hash string
+}
+
+func newScript(src string) *script {
+	h := sha1.Sum([]byte(src))
+	return &script{
+		src:  src,
+		hash: hex.EncodeToString(h[:]),
+	}
+}
+
+func (s *script) Run(ctx context.Context, c redis.Cmdable, keys []string, args ...interface{}) *redis.Cmd {
+	r := c.EvalSha(ctx, s.hash, keys, args...)
+	if err := r.Err(); err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		return c.Eval(ctx, s.src, keys, args...)
+	}
+	return r
+}
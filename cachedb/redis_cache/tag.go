@@ -0,0 +1,68 @@
+package redis_cache
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+var _ core.ITaggedCacheDB = (*redisCache)(nil)
+
+/*
+将key加入标签, 标签使用有序集合, 分数为key的过期时间的毫秒时间戳, key为永久时为 +inf.
+
+	加入时移除已过期的成员, 避免标签中的成员无限增长
+	标签的有效期延长到不小于key的有效期, key为永久时标签也为永久
+*/
+var addTagScript = newScript(`
+local now = tonumber(ARGV[3])
+local px = tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', '(' .. now)
+local existed = redis.call('EXISTS', KEYS[1])
+if px <= 0 then
+	redis.call('ZADD', KEYS[1], '+inf', ARGV[1])
+	redis.call('PERSIST', KEYS[1])
+	return 1
+end
+redis.call('ZADD', KEYS[1], now + px, ARGV[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if existed == 0 or (ttl >= 0 and ttl < px) then
+	redis.call('PEXPIRE', KEYS[1], px)
+end
+return 1
+`)
+
+func (r *redisCache) AddTags(ctx context.Context, key string, ttl time.Duration, tags ...string) error {
+	ms, now := px(ttl), time.Now().UnixMilli()
+	if len(tags) == 1 {
+		return addTagScript.Run(ctx, r.client, []string{tags[0]}, key, ms, now).Err()
+	}
+
+	// 管道中无法处理 NOSCRIPT 错误, 所以直接使用 EVAL
+	pipe := r.client.Pipeline()
+	for _, tag := range tags {
+		pipe.Eval(ctx, addTagScript.src, []string{tag}, key, ms, now)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// 获取标签下未过期的key
+func (r *redisCache) TagMembers(ctx context.Context, tag string) ([]string, error) {
+	return r.client.ZRangeByScore(ctx, tag, &goredis.ZRangeBy{
+		Min: strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+}
+
+func (r *redisCache) RemoveFromTag(ctx context.Context, tag string, keys ...string) error {
+	members := make([]interface{}, len(keys))
+	for i, key := range keys {
+		members[i] = key
+	}
+	return r.client.ZRem(ctx, tag, members...).Err()
+}
@@ -0,0 +1,94 @@
+package redis_cache
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/zly-app/component/redis"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+var _ core.IVersionedCacheDB = (*redisCache)(nil)
+var _ core.IVersionedExpireAtCacheDB = (*redisCache)(nil)
+
+// 版本key的有效期, 只需要覆盖加载数据的耗时
+const versionExpire = time.Hour
+
+// 版本key的后缀
+const versionKeySuffix = ":__ver"
+
+// 版本未改变时写入, ARGV[3] 为毫秒有效期, ARGV[4] 为截止时间的毫秒时间戳, 大于0时按截止时间写入
+var setIfVersionScript = newScript(`
+local v = redis.call('GET', KEYS[2]) or ''
+if v ~= ARGV[2] then
+	return 0
+end
+if tonumber(ARGV[4]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1])
+	redis.call('PEXPIREAT', KEYS[1], ARGV[4])
+elseif tonumber(ARGV[3]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+var delAndBumpVersionScript = newScript(`
+redis.call('DEL', KEYS[1])
+redis.call('INCR', KEYS[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[1])
+return 1
+`)
+
+/*
+获取key对应的版本key, 版本key和key必须在同一个slot中.
+
+	如果key中存在hash tag, 直接在key后面加上后缀, 此时hash tag不变
+	否则将key作为版本key的hash tag
+*/
+func versionKey(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key + versionKeySuffix
+		}
+	}
+	if strings.IndexByte(key, '}') >= 0 { // 无法作为hash tag, 仅在非集群模式下保证原子性
+		return key + versionKeySuffix
+	}
+	return "{" + key + "}" + versionKeySuffix
+}
+
+func (r *redisCache) GetVersion(ctx context.Context, key string) (string, error) {
+	v, err := r.client.Get(ctx, versionKey(key)).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return v, err
+}
+
+func (r *redisCache) SetIfVersion(ctx context.Context, key string, data []byte, ttl time.Duration, version string) (bool, error) {
+	n, err := setIfVersionScript.Run(ctx, r.client, []string{key, versionKey(key)}, data, version, px(ttl), 0).Int()
+	return n == 1, err
+}
+
+func (r *redisCache) SetAtIfVersion(ctx context.Context, key string, data []byte, expireAt time.Time, version string) (bool, error) {
+	n, err := setIfVersionScript.Run(ctx, r.client, []string{key, versionKey(key)}, data, version, 0, expireAt.UnixMilli()).Int()
+	return n == 1, err
+}
+
+func (r *redisCache) DelAndBumpVersion(ctx context.Context, keys ...string) error {
+	if len(keys) == 1 {
+		return delAndBumpVersionScript.Run(ctx, r.client, []string{keys[0], versionKey(keys[0])}, versionExpire.Milliseconds()).Err()
+	}
+
+	// 管道中无法处理 NOSCRIPT 错误, 所以直接使用 EVAL
+	pipe := r.client.Pipeline()
+	for _, key := range keys {
+		pipe.Eval(ctx, delAndBumpVersionScript.src, []string{key, versionKey(key)}, versionExpire.Milliseconds())
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
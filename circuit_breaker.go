@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zly-app/zapp/logger"
+	"go.uber.org/zap"
+)
+
+// 熔断器状态
+type breakerState int
+
+const (
+	breakerClosed   breakerState = 0 // 关闭, 正常访问缓存数据库
+	breakerOpen     breakerState = 1 // 打开, 跳过缓存数据库
+	breakerHalfOpen breakerState = 2 // 半开, 允许少量探测请求
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// 缓存数据库熔断器, 为nil时表示不启用
+type circuitBreaker struct {
+	cacheName      string
+	errRate        float64
+	minRequests    int
+	window         time.Duration
+	cooldown       time.Duration
+	halfOpenProbes int
+	onStateChange  func(state breakerState)
+
+	mx          sync.Mutex
+	state       breakerState
+	windowStart time.Time
+	total       int       // 窗口内请求数
+	failed      int       // 窗口内失败数
+	openTime    time.Time // 打开时间
+	probing     int       // 正在探测的请求数
+	probeOK     int       // 探测成功数
+}
+
+func newCircuitBreaker(cacheName string, conf *Config, onStateChange func(state breakerState)) *circuitBreaker {
+	if !conf.CircuitBreaker.Enable {
+		return nil
+	}
+	return &circuitBreaker{
+		cacheName:      cacheName,
+		errRate:        conf.CircuitBreaker.ErrorRate,
+		minRequests:    conf.CircuitBreaker.MinRequests,
+		window:         time.Duration(conf.CircuitBreaker.WindowSec) * time.Second,
+		cooldown:       time.Duration(conf.CircuitBreaker.CooldownSec) * time.Second,
+		halfOpenProbes: conf.CircuitBreaker.HalfOpenProbes,
+		onStateChange:  onStateChange,
+		windowStart:    time.Now(),
+	}
+}
+
+// 是否允许访问缓存数据库
+func (b *circuitBreaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openTime) < b.cooldown {
+			return false
+		}
+		logger.Log.Info("熔断器冷却结束, 进入半开状态", zap.String("cacheName", b.cacheName))
+		b.setState(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probing >= b.halfOpenProbes {
+			return false
+		}
+		b.probing++
+	}
+	return true
+}
+
+// 报告访问结果
+func (b *circuitBreaker) Report(err error) {
+	if b == nil {
+		return
+	}
+	failed := err != nil && err != ErrCacheMiss && err != context.Canceled
+
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	switch b.state {
+	case breakerClosed:
+		now := time.Now()
+		if now.Sub(b.windowStart) >= b.window {
+			b.windowStart = now
+			b.total, b.failed = 0, 0
+		}
+		b.total++
+		if failed {
+			b.failed++
+		}
+		if b.total >= b.minRequests && float64(b.failed)/float64(b.total) >= b.errRate {
+			logger.Log.Error("缓存数据库错误率过高, 熔断器打开", zap.String("cacheName", b.cacheName),
+				zap.Int("total", b.total), zap.Int("failed", b.failed), zap.Error(err))
+			b.setState(breakerOpen)
+		}
+	case breakerHalfOpen:
+		if b.probing > 0 {
+			b.probing--
+		}
+		if failed {
+			logger.Log.Warn("缓存数据库探测失败, 熔断器重新打开", zap.String("cacheName", b.cacheName), zap.Error(err))
+			b.setState(breakerOpen)
+			return
+		}
+		b.probeOK++
+		if b.probeOK >= b.halfOpenProbes {
+			logger.Log.Info("缓存数据库已恢复, 熔断器关闭", zap.String("cacheName", b.cacheName))
+			b.setState(breakerClosed)
+		}
+	}
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	b.state = state
+	b.probing, b.probeOK = 0, 0
+	switch state {
+	case breakerOpen:
+		b.openTime = time.Now()
+	case breakerClosed:
+		b.windowStart = time.Now()
+		b.total, b.failed = 0, 0
+	}
+	if b.onStateChange != nil {
+		b.onStateChange(state)
+	}
+}
@@ -2,12 +2,14 @@ package cache
 
 import (
 	"fmt"
+	"math"
 	"strings"
 
 	"github.com/zly-app/component/redis"
 	"github.com/zly-app/zapp/pkg/compactor"
 	"github.com/zly-app/zapp/pkg/serializer"
 
+	"github.com/zly-app/cache/v2/core"
 	"github.com/zly-app/cache/v2/single_flight"
 )
 
@@ -17,6 +19,32 @@ const (
 	defSingleFlight     = "single"
 	defExpireSec        = 300
 	defIgnoreCacheFault = false
+	defEnableMetrics    = true
+
+	defListenerQueueSize = 10000
+
+	defCircuitBreaker_ErrorRate      = 0.5
+	defCircuitBreaker_MinRequests    = 20
+	defCircuitBreaker_WindowSec      = 10
+	defCircuitBreaker_CooldownSec    = 5
+	defCircuitBreaker_HalfOpenProbes = 3
+
+	defHedge_MinDelayMs = 10
+
+	defRetry_BackoffMs    = 20
+	defRetry_MaxBackoffMs = 500
+	defRetry_Jitter       = 0.2
+
+	defLoader_QueueTimeoutMs = 1000
+
+	defErrorCache_BackoffMs    = 1000
+	defErrorCache_MaxBackoffMs = 60000
+
+	defWriteBehind_QueueSize    = 10000
+	defWriteBehind_Workers      = 4
+	defWriteBehind_Attempts     = 3
+	defWriteBehind_BackoffMs    = 100
+	defWriteBehind_MaxBackoffMs = 2000
 
 	defCacheDB_Type = "bigcache"
 
@@ -26,6 +54,9 @@ const (
 	defCacheDB_BigCache_MaxEntrySize       = 500
 
 	defCacheDB_FreeCache_SizeMB = 1
+
+	defCacheDB_Fallback_CheckIntervalSec = 5
+	defCacheDB_Fallback_FailThreshold    = 3
 )
 
 type Config struct {
@@ -34,6 +65,10 @@ type Config struct {
 	SingleFlight     string // 默认单跑模块, 可选 no, single
 	ExpireSec        int    // 默认过期时间, 秒, < 1 表示永久
 	IgnoreCacheFault bool   // 是否忽略缓存数据库故障, 如果设为true, 在缓存数据库故障时从加载器获取数据, 这会导致缓存击穿. 如果设为false, 在缓存数据库故障时直接返回错误
+	EnableMetrics    bool   // 是否启用指标上报, 包括命中率, 加载耗时, 缓存数据库操作耗时, 错误数和SingleFlight等待者数量, 通过zapp的metrics组件上报
+	TraceHashKey     bool   // 链路追踪中是否只记录key的hash值, 用于避免key中的敏感信息出现在链路中
+	KeyPrefix        string // key前缀, 写入缓存数据库的key会变为 {KeyPrefix}:{key}, 用于多个服务共用一个redis时隔离key
+	Version          int    // 数据版本, 写入缓存数据库的key会加上 v{Version}: 前缀, 修改数据结构后增加版本可以使旧数据失效, < 1 表示不使用
 	CacheDB          struct {
 		Type     string // 缓存数据库类型, 支持 no, bigcache, freecache, redis
 		BigCache struct {
@@ -49,7 +84,94 @@ type Config struct {
 		}
 		RedisName string // redis组件名, 如果设置, 将使用该redis组件, 且以下redis配置无效
 		Redis     redis.RedisConfig
+		Fallback  struct {
+			Type             string // 备用缓存数据库类型, 支持 bigcache, freecache, 为空表示不启用. 主缓存数据库故障时读写切换到备用缓存数据库, 备用缓存数据库使用上面的对应配置
+			CheckIntervalSec int    // 使用备用缓存数据库期间对主缓存数据库进行健康检查的间隔秒数
+			FailThreshold    int    // 主缓存数据库连续失败多少次后切换到备用缓存数据库
+		}
+	}
+
+	// 缓存数据库熔断器, 打开后直接跳过缓存数据库, 返回 ErrCircuitOpen 并按照 IgnoreCacheFault 处理: 忽略缓存故障时Get会直接从加载函数加载数据且不写入缓存, Set和Del返回 ErrCircuitOpen
+	CircuitBreaker struct {
+		Enable         bool    // 是否启用
+		ErrorRate      float64 // 错误率阈值, 窗口内错误率达到该值时打开熔断器, 范围 (0, 1]
+		MinRequests    int     // 窗口内最少请求数, 请求数不足时不会打开熔断器
+		WindowSec      int     // 统计窗口秒数
+		CooldownSec    int     // 打开后的冷却秒数, 冷却结束后进入半开状态
+		HalfOpenProbes int     // 半开状态允许的探测请求数, 探测全部成功后关闭熔断器
+	}
+
+	// 缓存数据库操作超时, 单位毫秒, 0 表示不限制. 超时视为缓存数据库故障, 按照 IgnoreCacheFault 处理
+	Timeout struct {
+		GetMs int
+		SetMs int
+		DelMs int
+	}
+
+	// 对冲读, 缓存数据库Get在p95耗时内未返回时发起第二个请求, 使用先返回的成功结果. 适用于redis读副本等场景
+	Hedge struct {
+		Enable     bool // 是否启用
+		MinDelayMs int  // 发起第二个请求前的最小等待毫秒数, 在样本不足时使用该值
+	}
+
+	// 缓存数据库重试策略, 与redis客户端的 MaxRetries 相互独立. 重试不会超过ctx的截止时间
+	Retry struct {
+		Attempts     int                  // 最大尝试次数, < 2 表示不重试
+		BackoffMs    int                  // 首次重试前的等待毫秒数, 之后每次翻倍
+		MaxBackoffMs int                  // 最大等待毫秒数
+		Jitter       float64              // 等待时间的抖动比例, 范围 [0, 1)
+		Retryable    func(err error) bool // 判断错误是否可重试, 为nil时重试所有缓存数据库故障, 只能通过代码设置
+	}
+
+	// 加载函数限制, 全局限制通过 SetGlobalLoaderLimit 设置
+	Loader struct {
+		MaxConcurrency int // 该cache加载函数最大并发数, < 1 表示不限制
+		QueueTimeoutMs int // 并发数已满时排队等待的最大毫秒数, 对全局加载并发限制同样生效, 超时返回 ErrLoaderOverloaded, < 1 表示只受ctx截止时间限制
+		MinIntervalMs  int // 同一个key两次加载的最小间隔毫秒数, 从上次开始加载计算, 加载失败同样计算, 间隔内再次加载返回 ErrLoadTooFrequent, < 1 表示不限制
 	}
+
+	// 加载错误缓存, 加载函数失败后在退避时间内直接返回缓存的错误而不再调用加载函数. 退避时间从 BackoffMs 开始每次失败翻倍, 加载成功后重置
+	ErrorCache struct {
+		Enable       bool // 是否启用
+		BackoffMs    int  // 首次失败后的退避毫秒数
+		MaxBackoffMs int  // 最大退避毫秒数
+	}
+
+	// 延迟写入, 启用后可以在 Save 时使用 WithWriteBehind
+	WriteBehind struct {
+		Enable       bool // 是否启用
+		QueueSize    int  // 等待写入的最大key数量, 队列满时 Save 返回 ErrWriteBehindQueueFull
+		Workers      int  // 写入协程数, 同一个key总是由同一个协程写入
+		Attempts     int  // 写入失败时的最大尝试次数
+		BackoffMs    int  // 首次重试前的等待毫秒数, 之后每次翻倍
+		MaxBackoffMs int  // 最大等待毫秒数
+	}
+
+	// 滑动过期, 命中时将key的有效期重置为本次调用的有效期, key在有效期内没有被访问才会过期. redis使用 GETEX 实现, 要求 redis >= 6.2.0
+	SlidingExpire struct {
+		Enable         bool // 是否默认启用, 可以通过 WithSlidingExpire 对单次调用设置
+		MaxLifetimeSec int  // 从写入开始计算的最大存活秒数, 超过后即使一直被访问也会过期, < 1 表示不限制
+	}
+
+	// 旧数据写入保护, 删除时更新key的版本, 加载期间版本改变时加载的数据不会写入缓存. redis使用版本key实现, 其它缓存数据库使用本地版本表
+	StaleWriteProtect bool
+
+	// 解码失败视为未命中, 缓存中的数据无法解压缩或反序列化时删除它, 然后通过加载函数重新加载并覆盖. 适用于结构体变更或更换序列化器后读取旧数据
+	DecodeErrorAsMiss bool
+
+	// 数据升级, 读取到旧版本的数据时依次调用升级函数升级到当前版本后再解码
+	Upcast struct {
+		SchemaVersion int                   // 当前的数据结构版本, 写入的数据会记录该版本, 范围为 0-65535
+		Upcasters     map[int]core.Upcaster // 升级函数, key为升级前的版本, 只能通过代码设置
+		WriteBack     bool                  // 是否将升级后的数据写回缓存
+	}
+
+	// 依赖追踪, 加载函数中通过 Get 读取的key或通过 DependOn 声明的key会被记录为依赖, 依赖的key被删除或更新时递归删除依赖它的key. 启用后 Set 和 Del 会额外查询依赖
+	EnableDependency bool
+
+	Listeners         []core.IListener // 事件监听器, 只能通过代码设置
+	ListenerAsync     bool             // 是否异步投递事件, 如果设为true, 事件会放入队列由后台协程投递, 队列满时丢弃事件
+	ListenerQueueSize int              // 异步投递事件的队列大小
 }
 
 func NewConfig() *Config {
@@ -59,13 +181,42 @@ func NewConfig() *Config {
 		SingleFlight:     defSingleFlight,
 		ExpireSec:        defExpireSec,
 		IgnoreCacheFault: defIgnoreCacheFault,
+		EnableMetrics:    defEnableMetrics,
 	}
 
+	conf.ListenerQueueSize = defListenerQueueSize
+
+	conf.CircuitBreaker.ErrorRate = defCircuitBreaker_ErrorRate
+	conf.CircuitBreaker.MinRequests = defCircuitBreaker_MinRequests
+	conf.CircuitBreaker.WindowSec = defCircuitBreaker_WindowSec
+	conf.CircuitBreaker.CooldownSec = defCircuitBreaker_CooldownSec
+	conf.CircuitBreaker.HalfOpenProbes = defCircuitBreaker_HalfOpenProbes
+
+	conf.Hedge.MinDelayMs = defHedge_MinDelayMs
+
+	conf.Retry.BackoffMs = defRetry_BackoffMs
+	conf.Retry.MaxBackoffMs = defRetry_MaxBackoffMs
+	conf.Retry.Jitter = defRetry_Jitter
+
+	conf.Loader.QueueTimeoutMs = defLoader_QueueTimeoutMs
+
+	conf.ErrorCache.BackoffMs = defErrorCache_BackoffMs
+	conf.ErrorCache.MaxBackoffMs = defErrorCache_MaxBackoffMs
+
+	conf.WriteBehind.QueueSize = defWriteBehind_QueueSize
+	conf.WriteBehind.Workers = defWriteBehind_Workers
+	conf.WriteBehind.Attempts = defWriteBehind_Attempts
+	conf.WriteBehind.BackoffMs = defWriteBehind_BackoffMs
+	conf.WriteBehind.MaxBackoffMs = defWriteBehind_MaxBackoffMs
+
 	conf.CacheDB.Type = defCacheDB_Type
 
 	conf.CacheDB.BigCache.CleanTimeSec = defCacheDB_BigCache_CleanTimeSec
 
 	conf.CacheDB.FreeCache.SizeMB = defCacheDB_FreeCache_SizeMB
+
+	conf.CacheDB.Fallback.CheckIntervalSec = defCacheDB_Fallback_CheckIntervalSec
+	conf.CacheDB.Fallback.FailThreshold = defCacheDB_Fallback_FailThreshold
 	return conf
 }
 
@@ -73,6 +224,12 @@ func (conf *Config) Check() error {
 	if conf.ExpireSec < 1 {
 		conf.ExpireSec = 0
 	}
+	if conf.Version < 1 {
+		conf.Version = 0
+	}
+	if conf.SlidingExpire.MaxLifetimeSec < 1 {
+		conf.SlidingExpire.MaxLifetimeSec = 0
+	}
 
 	switch v := strings.ToLower(conf.CacheDB.Type); v {
 	case "":
@@ -119,8 +276,95 @@ func (conf *Config) Check() error {
 		conf.CacheDB.BigCache.HardMaxCacheSize = 0
 	}
 
+	if conf.ListenerQueueSize < 1 {
+		conf.ListenerQueueSize = defListenerQueueSize
+	}
+
+	if conf.CircuitBreaker.ErrorRate <= 0 || conf.CircuitBreaker.ErrorRate > 1 {
+		conf.CircuitBreaker.ErrorRate = defCircuitBreaker_ErrorRate
+	}
+	if conf.CircuitBreaker.MinRequests < 1 {
+		conf.CircuitBreaker.MinRequests = defCircuitBreaker_MinRequests
+	}
+	if conf.CircuitBreaker.WindowSec < 1 {
+		conf.CircuitBreaker.WindowSec = defCircuitBreaker_WindowSec
+	}
+	if conf.CircuitBreaker.CooldownSec < 1 {
+		conf.CircuitBreaker.CooldownSec = defCircuitBreaker_CooldownSec
+	}
+	if conf.CircuitBreaker.HalfOpenProbes < 1 {
+		conf.CircuitBreaker.HalfOpenProbes = defCircuitBreaker_HalfOpenProbes
+	}
+
+	if conf.Timeout.GetMs < 0 {
+		conf.Timeout.GetMs = 0
+	}
+	if conf.Timeout.SetMs < 0 {
+		conf.Timeout.SetMs = 0
+	}
+	if conf.Timeout.DelMs < 0 {
+		conf.Timeout.DelMs = 0
+	}
+	if conf.Hedge.MinDelayMs < 1 {
+		conf.Hedge.MinDelayMs = defHedge_MinDelayMs
+	}
+
+	if conf.Retry.BackoffMs < 1 {
+		conf.Retry.BackoffMs = defRetry_BackoffMs
+	}
+	if conf.Retry.MaxBackoffMs < conf.Retry.BackoffMs {
+		conf.Retry.MaxBackoffMs = conf.Retry.BackoffMs
+	}
+	if conf.Retry.Jitter < 0 || conf.Retry.Jitter >= 1 {
+		conf.Retry.Jitter = defRetry_Jitter
+	}
+
+	if conf.ErrorCache.BackoffMs < 1 {
+		conf.ErrorCache.BackoffMs = defErrorCache_BackoffMs
+	}
+	if conf.ErrorCache.MaxBackoffMs < conf.ErrorCache.BackoffMs {
+		conf.ErrorCache.MaxBackoffMs = conf.ErrorCache.BackoffMs
+	}
+
+	if conf.WriteBehind.QueueSize < 1 {
+		conf.WriteBehind.QueueSize = defWriteBehind_QueueSize
+	}
+	if conf.WriteBehind.Workers < 1 {
+		conf.WriteBehind.Workers = defWriteBehind_Workers
+	}
+	if conf.WriteBehind.Attempts < 1 {
+		conf.WriteBehind.Attempts = defWriteBehind_Attempts
+	}
+	if conf.WriteBehind.BackoffMs < 1 {
+		conf.WriteBehind.BackoffMs = defWriteBehind_BackoffMs
+	}
+	if conf.WriteBehind.MaxBackoffMs < conf.WriteBehind.BackoffMs {
+		conf.WriteBehind.MaxBackoffMs = conf.WriteBehind.BackoffMs
+	}
+
+	if conf.Upcast.SchemaVersion < 0 || conf.Upcast.SchemaVersion > math.MaxUint16 {
+		return fmt.Errorf("Upcast.SchemaVersion 超出范围: %v", conf.Upcast.SchemaVersion)
+	}
+	for v, fn := range conf.Upcast.Upcasters {
+		if v < 0 || v >= conf.Upcast.SchemaVersion || fn == nil {
+			return fmt.Errorf("无效的升级函数, 版本: %v", v)
+		}
+	}
+
 	if conf.CacheDB.FreeCache.SizeMB < 1 {
 		conf.CacheDB.FreeCache.SizeMB = defCacheDB_FreeCache_SizeMB
 	}
+
+	switch v := strings.ToLower(conf.CacheDB.Fallback.Type); v {
+	case "", "bigcache", "freecache":
+	default:
+		return fmt.Errorf("不支持的Fallback CacheDB: %v", v)
+	}
+	if conf.CacheDB.Fallback.CheckIntervalSec < 1 {
+		conf.CacheDB.Fallback.CheckIntervalSec = defCacheDB_Fallback_CheckIntervalSec
+	}
+	if conf.CacheDB.Fallback.FailThreshold < 1 {
+		conf.CacheDB.Fallback.FailThreshold = defCacheDB_Fallback_FailThreshold
+	}
 	return nil
 }
@@ -2,12 +2,26 @@ package core
 
 import (
 	"context"
+	"time"
 )
 
 type Option func(opts interface{})
 
+// 加载函数, 可以返回 LoadResult 或 *LoadResult 控制写入缓存的方式
 type LoadFn func(ctx context.Context, key string) (interface{}, error)
 
+// 加载结果
+type LoadResult struct {
+	Value     interface{}   // 加载到的数据
+	TTL       time.Duration // 有效期, 0 表示使用本次调用的有效期, < 0 表示永不过期
+	DontCache bool          // 不要写入缓存
+}
+
+// 数据升级函数, 将未压缩的 version 版本的数据升级为 version+1 版本, 升级后的数据必须使用同一个序列化器
+type Upcaster func(data []byte, serializer ISerializer) ([]byte, error)
+
+type SaveFn func(ctx context.Context, key string, data interface{}) error
+
 type ICache interface {
 	// 获取数据并放入 aPtr 中
 	Get(ctx context.Context, key string, aPtr interface{}, opts ...Option) error
@@ -18,9 +32,36 @@ type ICache interface {
 	// 单跑执行, 忽略缓存直接从db加载数据, 默认不会自动写入缓存, 必须设置 LoadFn
 	SingleFlightDo(ctx context.Context, key string, aPtr interface{}, opts ...Option) error
 
+	// 保存数据, 通过 SaveFn 持久化并写入缓存, 必须设置 SaveFn. 默认为直写, 可以设置为延迟写入
+	Save(ctx context.Context, key string, data interface{}, opts ...Option) error
+
 	// 删除
 	Del(ctx context.Context, keys ...string) error
 
+	// 检查key是否存在, 不会读取数据也不会计入命中率
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// 获取key剩余的有效期, key不存在时返回 ErrCacheMiss, 永不过期时返回 NoExpire
+	TTL(ctx context.Context, key string) (time.Duration, error)
+
+	// 修改key的有效期, expireSec < 0 表示永不过期, expireSec = 0 表示使用默认值, key不存在时返回 ErrCacheMiss
+	Touch(ctx context.Context, key string, expireSec int) error
+
+	// 删除前缀为prefix的所有key
+	DelPrefix(ctx context.Context, prefix string) error
+
+	// 遍历前缀为prefix的key, fn返回false时停止遍历. 可能会遍历到重复的key
+	Scan(ctx context.Context, prefix string, fn func(key string) bool) error
+
+	// 清空缓存, 设置了 KeyPrefix 时只删除该前缀下的key
+	Clear(ctx context.Context) error
+
+	// 删除标签下的所有key, 标签通过 WithTags 设置
+	DelByTag(ctx context.Context, tag string) error
+
+	// 延迟双删, 立即删除并在 delay 后再次删除, 用于避免更新db期间其它请求将旧数据写回缓存. 关闭时会立即执行未到期的删除
+	DelWithDelay(ctx context.Context, delay time.Duration, keys ...string) error
+
 	// 关闭
 	Close() error
 }
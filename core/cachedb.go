@@ -2,15 +2,19 @@ package core
 
 import (
 	"context"
+	"time"
 )
 
+// TTL 返回该值表示永不过期
+const NoExpire time.Duration = -1
+
 // 缓存数据库接口
 type ICacheDB interface {
 	// 获取一个值
 	Get(ctx context.Context, key string) ([]byte, error)
 
-	// 设置一个值, expireSec <= 0 时表示永不过期
-	Set(ctx context.Context, key string, data []byte, expireSec int) error
+	// 设置一个值, ttl <= 0 时表示永不过期
+	Set(ctx context.Context, key string, data []byte, ttl time.Duration) error
 
 	// 删除数据
 	Del(ctx context.Context, keys ...string) error
@@ -18,3 +22,87 @@ type ICacheDB interface {
 	// 关闭
 	Close() error
 }
+
+// 支持版本控制的缓存数据库, 用于防止删除和加载并发时加载到的旧数据被写回缓存
+type IVersionedCacheDB interface {
+	// 获取key当前的版本, 在加载数据前调用
+	GetVersion(ctx context.Context, key string) (string, error)
+
+	// 版本未改变时才写入数据, 返回是否写入
+	SetIfVersion(ctx context.Context, key string, data []byte, ttl time.Duration, version string) (bool, error)
+
+	// 删除数据并更新版本
+	DelAndBumpVersion(ctx context.Context, keys ...string) error
+}
+
+// 支持标签的缓存数据库, 不支持时由cache在本地维护标签索引. 传入的标签为保存标签成员的key, 已由cache加上key前缀
+type ITaggedCacheDB interface {
+	// 将key加入标签, ttl 为key的有效期, 标签会在其中所有key过期后过期
+	AddTags(ctx context.Context, key string, ttl time.Duration, tags ...string) error
+
+	// 获取标签下的所有key
+	TagMembers(ctx context.Context, tag string) ([]string, error)
+
+	// 从标签中移除key
+	RemoveFromTag(ctx context.Context, tag string, keys ...string) error
+}
+
+// 支持遍历和按前缀删除的缓存数据库
+type IScanCacheDB interface {
+	// 遍历前缀为prefix的key, fn返回false时停止遍历. 可能会遍历到重复的key
+	Scan(ctx context.Context, prefix string, fn func(key string) bool) error
+
+	// 删除前缀为prefix的所有key
+	DelPrefix(ctx context.Context, prefix string) error
+
+	// 清空所有数据
+	Clear(ctx context.Context) error
+}
+
+// 支持过期时间操作的缓存数据库
+type IExpireCacheDB interface {
+	// 检查key是否存在
+	Exists(ctx context.Context, key string) (bool, error)
+
+	// 获取key剩余的有效期, key不存在时返回 errs.CacheMiss, 永不过期时返回 NoExpire
+	TTL(ctx context.Context, key string) (time.Duration, error)
+
+	// 修改key的有效期, ttl <= 0 时表示永不过期, key不存在时返回 errs.CacheMiss
+	Touch(ctx context.Context, key string, ttl time.Duration) error
+}
+
+// 支持滑动过期的缓存数据库, 不支持时由cache通过 Get 和 Touch 实现
+type ISlidingCacheDB interface {
+	// 获取一个值并将有效期重置为 ttl, ttl <= 0 时表示永不过期
+	GetAndTouch(ctx context.Context, key string, ttl time.Duration) ([]byte, error)
+}
+
+// 支持比较后写入的缓存数据库
+type ICompareAndSetCacheDB interface {
+	// 当前数据与 old 相同时写入 data, key不存在时不写入. 返回是否已写入
+	CompareAndSet(ctx context.Context, key string, old, data []byte, ttl time.Duration) (bool, error)
+}
+
+// 支持按截止时间写入的缓存数据库, 截止时间不受请求排队和网络延迟影响
+type IExpireAtCacheDB interface {
+	// 设置一个值并在 expireAt 时过期, expireAt 已过时数据会立即过期
+	SetAt(ctx context.Context, key string, data []byte, expireAt time.Time) error
+}
+
+// 可能被多个服务共用, 不允许清空整个数据库的缓存数据库
+type IClearGuardCacheDB interface {
+	// 检查是否允许清空整个数据库, 不允许时返回原因
+	CheckClear() error
+}
+
+// 支持按截止时间按版本写入的缓存数据库
+type IVersionedExpireAtCacheDB interface {
+	// 版本未改变时才写入数据并在 expireAt 时过期, 返回是否写入
+	SetAtIfVersion(ctx context.Context, key string, data []byte, expireAt time.Time, version string) (bool, error)
+}
+
+// 包装其他缓存数据库的缓存数据库, 可选接口是否可用以被包装的缓存数据库为准
+type IWrappedCacheDB interface {
+	// 返回被包装的缓存数据库
+	Unwrap() ICacheDB
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+)
+
+// 淘汰原因
+type EvictReason int
+
+const (
+	// 过期
+	EvictReasonExpired EvictReason = 1
+	// 空间不足
+	EvictReasonNoSpace EvictReason = 2
+)
+
+func (r EvictReason) String() string {
+	switch r {
+	case EvictReasonExpired:
+		return "expired"
+	case EvictReasonNoSpace:
+		return "no_space"
+	}
+	return "unknown"
+}
+
+// 事件监听器, 异步投递时 ctx 可能已经结束, 监听器不应该再用它发起请求
+type IListener interface {
+	// 缓存命中
+	OnHit(ctx context.Context, key string)
+	// 缓存未命中
+	OnMiss(ctx context.Context, key string)
+	// 从加载函数加载数据后, err 为加载函数返回的错误
+	OnLoad(ctx context.Context, key string, err error)
+	// 写入缓存后
+	OnSet(ctx context.Context, key string, err error)
+	// 删除缓存后
+	OnDel(ctx context.Context, keys []string, err error)
+	// 数据被缓存数据库淘汰, 仅支持淘汰通知的缓存数据库会触发
+	OnEvict(key string, reason EvictReason)
+	// 出现错误, 如缓存数据库故障
+	OnError(ctx context.Context, key string, err error)
+}
+
+// 淘汰回调
+type EvictCallback func(key string, reason EvictReason)
+
+// 支持淘汰通知的缓存数据库
+type IEvictNotifier interface {
+	// 设置淘汰回调, 必须在使用前设置
+	SetEvictCallback(fn EvictCallback)
+}
+
+// 空的事件监听器, 可以嵌入到自定义监听器中以只实现关心的事件
+type NoopListener struct{}
+
+func (NoopListener) OnHit(ctx context.Context, key string)               {}
+func (NoopListener) OnMiss(ctx context.Context, key string)              {}
+func (NoopListener) OnLoad(ctx context.Context, key string, err error)   {}
+func (NoopListener) OnSet(ctx context.Context, key string, err error)    {}
+func (NoopListener) OnDel(ctx context.Context, keys []string, err error) {}
+func (NoopListener) OnEvict(key string, reason EvictReason)              {}
+func (NoopListener) OnError(ctx context.Context, key string, err error)  {}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+// 为缓存数据库操作设置超时, timeout <= 0 表示不限制
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// 获取传给缓存数据库的key, 会加上前缀和版本
+func (c *Cache) dbKey(key string) string {
+	if c.keyPrefix == "" {
+		return key
+	}
+	return c.keyPrefix + key
+}
+
+func (c *Cache) dbKeys(keys []string) []string {
+	if c.keyPrefix == "" {
+		return keys
+	}
+	ks := make([]string, len(keys))
+	for i, key := range keys {
+		ks[i] = c.keyPrefix + key
+	}
+	return ks
+}
+
+// 将缓存数据库中的key还原为用户的key, 前缀或版本不匹配时返回false
+func (c *Cache) userKey(key string) (string, bool) {
+	if c.keyPrefix == "" {
+		return key, true
+	}
+	if !strings.HasPrefix(key, c.keyPrefix) {
+		return "", false
+	}
+	return key[len(c.keyPrefix):], true
+}
+
+// 将缓存数据库中的key还原为用户的key, 忽略前缀或版本不匹配的key
+func (c *Cache) userKeys(keys []string) []string {
+	if c.keyPrefix == "" {
+		return keys
+	}
+	ks := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if k, ok := c.userKey(key); ok {
+			ks = append(ks, k)
+		}
+	}
+	return ks
+}
+
+// 生成key前缀, 格式为 {KeyPrefix}:v{Version}:
+func makeKeyPrefix(keyPrefix string, version int) string {
+	var prefix string
+	if keyPrefix != "" {
+		prefix = keyPrefix + ":"
+	}
+	if version > 0 {
+		prefix += "v" + strconv.Itoa(version) + ":"
+	}
+	return prefix
+}
+
+// 执行缓存数据库操作, 统一处理熔断, 链路, 重试和指标
+func (c *Cache) dbDo(ctx context.Context, op string, timeout time.Duration, keys []string, fn func(ctx context.Context) error) error {
+	if !c.breaker.Allow() {
+		c.metrics.BreakerReject(op)
+		return ErrCircuitOpen
+	}
+
+	ctx = c.startSpan(ctx, "cache/db."+op, keys...)
+	startTime := time.Now()
+	err := c.doWithRetry(ctx, op, timeout, fn)
+	c.metrics.ObserveDB(op, startTime)
+	c.breaker.Report(err)
+	if err != nil && err != ErrCacheMiss {
+		c.metrics.Err(op)
+	}
+	c.endSpan(ctx, err)
+	return err
+}
+
+// 从缓存数据库获取数据
+func (c *Cache) dbGet(ctx context.Context, key string) ([]byte, error) {
+	var bs []byte
+	err := c.dbDo(ctx, opGet, c.getTimeout, []string{key}, func(ctx context.Context) (err error) {
+		if c.hedge != nil {
+			bs, err = c.hedgedGet(ctx, c.dbKey(key))
+		} else {
+			bs, err = c.cacheDB.Get(ctx, c.dbKey(key))
+		}
+		return err
+	})
+	switch err {
+	case nil:
+		c.metrics.Hit(opGet)
+	case ErrCacheMiss:
+		c.metrics.Miss(opGet)
+	}
+	return bs, err
+}
+
+// 写入数据到缓存数据库, expireAt 不为零值并且缓存数据库支持时按截止时间写入, 重试时截止时间不变
+func (c *Cache) dbSet(ctx context.Context, key string, bs []byte, ttl time.Duration, expireAt time.Time) error {
+	return c.dbDo(ctx, opSet, c.setTimeout, []string{key}, func(ctx context.Context) error {
+		db, ok := c.cacheDB.(core.IExpireAtCacheDB)
+		if _, supported := unwrapCacheDB(c.cacheDB).(core.IExpireAtCacheDB); ok && supported && !expireAt.IsZero() {
+			return db.SetAt(ctx, c.dbKey(key), bs, expireAt)
+		}
+		return c.cacheDB.Set(ctx, c.dbKey(key), bs, ttl)
+	})
+}
+
+// 从缓存数据库删除数据
+func (c *Cache) dbDel(ctx context.Context, keys ...string) error {
+	return c.dbDo(ctx, opDel, c.delTimeout, keys, func(ctx context.Context) error {
+		return c.cacheDB.Del(ctx, c.dbKeys(keys)...)
+	})
+}
+
+// 获取key的版本, 缓存数据库必须实现 core.IVersionedCacheDB
+func (c *Cache) dbGetVersion(ctx context.Context, key string) (string, error) {
+	var version string
+	err := c.dbDo(ctx, opGetVersion, c.getTimeout, []string{key}, func(ctx context.Context) (err error) {
+		version, err = c.cacheDB.(core.IVersionedCacheDB).GetVersion(ctx, c.dbKey(key))
+		return err
+	})
+	return version, err
+}
+
+// 版本未改变时写入数据, 缓存数据库必须实现 core.IVersionedCacheDB. 设置了截止时间并且缓存数据库支持时按截止时间写入
+func (c *Cache) dbSetIfVersion(ctx context.Context, key string, bs []byte, ttl time.Duration, expireAt time.Time, version string) (bool, error) {
+	var written bool
+	err := c.dbDo(ctx, opSet, c.setTimeout, []string{key}, func(ctx context.Context) (err error) {
+		db, ok := c.cacheDB.(core.IVersionedExpireAtCacheDB)
+		if _, supported := unwrapCacheDB(c.cacheDB).(core.IVersionedExpireAtCacheDB); ok && supported && !expireAt.IsZero() {
+			written, err = db.SetAtIfVersion(ctx, c.dbKey(key), bs, expireAt, version)
+			return err
+		}
+		written, err = c.cacheDB.(core.IVersionedCacheDB).SetIfVersion(ctx, c.dbKey(key), bs, ttl, version)
+		return err
+	})
+	return written, err
+}
+
+// 删除数据并更新版本, 缓存数据库必须实现 core.IVersionedCacheDB
+func (c *Cache) dbDelAndBumpVersion(ctx context.Context, keys ...string) error {
+	return c.dbDo(ctx, opDel, c.delTimeout, keys, func(ctx context.Context) error {
+		return c.cacheDB.(core.IVersionedCacheDB).DelAndBumpVersion(ctx, c.dbKeys(keys)...)
+	})
+}
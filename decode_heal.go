@@ -0,0 +1,83 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zly-app/zapp/logger"
+	"github.com/zly-app/zapp/pkg/utils"
+	"go.uber.org/zap"
+
+	"github.com/zly-app/cache/v2/errs"
+)
+
+// 解码失败日志的最小间隔
+const decodeErrLogInterval = time.Second
+
+// 日志限流器, 在间隔内只允许输出一条日志
+type logLimiter struct {
+	interval time.Duration
+
+	mx         sync.Mutex
+	last       time.Time
+	suppressed int // 上次输出后被忽略的日志数量
+}
+
+func newLogLimiter(interval time.Duration) *logLimiter {
+	return &logLimiter{interval: interval}
+}
+
+// 是否允许输出日志, 允许时返回上次输出后被忽略的日志数量
+func (l *logLimiter) Allow() (bool, int) {
+	now := time.Now()
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	if now.Sub(l.last) < l.interval {
+		l.suppressed++
+		return false, 0
+	}
+	suppressed := l.suppressed
+	l.last = now
+	l.suppressed = 0
+	return true, suppressed
+}
+
+// 是否为缓存数据解码失败
+func isDecodeError(err error) bool {
+	var codecErr *CodecError
+	if !errors.As(err, &codecErr) {
+		return false
+	}
+	switch codecErr.Stage {
+	case errs.StageEnvelope, errs.StageUncompress, errs.StageUpcast, errs.StageDeserialize:
+		return true
+	}
+	return false
+}
+
+// 处理缓存数据解码失败, 删除缓存后通过加载函数重新加载并覆盖. 没有加载函数时返回 ErrCacheMiss
+func (c *Cache) healDecodeError(ctx context.Context, key string, aPtr interface{}, opt *options, decodeErr error) error {
+	c.metrics.DecodeErr(opGet)
+	utils.Otel.CtxErrEvent(ctx, "DecodeErr", decodeErr)
+	if ok, suppressed := c.decodeErrLog.Allow(); ok {
+		logger.Log.Warn("缓存数据解码失败, 将删除并重新加载", zap.String("cacheName", c.cacheName),
+			zap.String("key", key), zap.Int("suppressed", suppressed), zap.Error(decodeErr))
+	}
+
+	err := c.delKeys(ctx, key)
+	if err != nil && err != ErrCircuitOpen {
+		logger.Log.Error("删除解码失败的缓存数据失败", zap.String("key", key), zap.Error(err))
+	}
+	if opt.LoadFn == nil {
+		return ErrCacheMiss
+	}
+
+	bs, err := c.sfDo(ctx, key, opt)
+	if err != nil {
+		return err
+	}
+	return c.unmarshalQuery(key, bs, aPtr, opt.Serializer, opt.Compactor)
+}
@@ -2,8 +2,13 @@ package cache
 
 import (
 	"context"
+	"time"
 
 	"github.com/zly-app/zapp/filter"
+	"github.com/zly-app/zapp/pkg/utils"
+
+	"github.com/zly-app/cache/v2/core"
+	"github.com/zly-app/cache/v2/errs"
 )
 
 func (c *Cache) Del(ctx context.Context, keys ...string) error {
@@ -11,13 +16,65 @@ func (c *Cache) Del(ctx context.Context, keys ...string) error {
 	r := &keys
 	_, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
 		r := req.(*[]string)
+		c.setSpanAttr(ctx, c.traceKeyAttr(*r...), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
 		err := c.del(ctx, *r...)
 		return nil, err
 	})
 	return err
 }
 
+type delWithDelayReq struct {
+	Keys  []string
+	Delay time.Duration
+}
+
+func (c *Cache) DelWithDelay(ctx context.Context, delay time.Duration, keys ...string) error {
+	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "DelWithDelay")
+	r := &delWithDelayReq{Keys: keys, Delay: delay}
+	_, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
+		r := req.(*delWithDelayReq)
+		c.setSpanAttr(ctx, c.traceKeyAttr(r.Keys...), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrDelayMs).Int64(r.Delay.Milliseconds()))
+
+		err := c.del(ctx, r.Keys...)
+		if r.Delay <= 0 {
+			return nil, err
+		}
+		// 立即删除失败时仍然需要延迟删除. 复制keys, 避免调用方在延迟期间修改切片
+		if scheduleErr := c.delayDel.Schedule(r.Delay, append([]string(nil), r.Keys...)); scheduleErr != nil {
+			return nil, scheduleErr
+		}
+		return nil, err
+	})
+	return err
+}
+
 func (c *Cache) del(ctx context.Context, keys ...string) error {
-	err := c.cacheDB.Del(ctx, keys...)
+	err := c.delKeys(ctx, keys...)
+	if err == nil && c.enableDependency {
+		err = c.delDependents(ctx, keys...)
+	}
+	if err != nil && len(keys) > 0 {
+		return &CacheFaultError{Key: keys[0], Stage: errs.StageDel, Err: err}
+	}
+	return err
+}
+
+// 删除keys, 不处理依赖
+func (c *Cache) delKeys(ctx context.Context, keys ...string) error {
+	var err error
+	switch {
+	case c.staleWriteProtect && c.leases == nil:
+		err = c.dbDelAndBumpVersion(ctx, keys...)
+	case c.staleWriteProtect:
+		c.leases.Invalidate(keys...)
+		err = c.dbDel(ctx, keys...)
+	default:
+		err = c.dbDel(ctx, keys...)
+	}
+	if err == nil && c.tagIndex != nil {
+		c.tagIndex.RemoveKeys(keys...)
+	}
+	c.listener.Emit(nil, func(l core.IListener) { l.OnDel(ctx, keys, err) })
 	return err
 }
@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/zly-app/zapp/logger"
+	"go.uber.org/zap"
+)
+
+type delayDelTask struct {
+	keys  []string
+	timer *time.Timer
+}
+
+// 延迟删除, 关闭时会立即执行所有未到期的删除
+type delayDeleter struct {
+	cache *Cache
+
+	mx     sync.Mutex
+	closed bool
+	tasks  map[*delayDelTask]struct{}
+	wg     sync.WaitGroup
+}
+
+func newDelayDeleter(cache *Cache) *delayDeleter {
+	return &delayDeleter{
+		cache: cache,
+		tasks: make(map[*delayDelTask]struct{}),
+	}
+}
+
+// 在delay后删除keys
+func (d *delayDeleter) Schedule(delay time.Duration, keys []string) error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if d.closed {
+		return ErrCacheClosed
+	}
+	task := &delayDelTask{keys: keys}
+	d.tasks[task] = struct{}{}
+	d.wg.Add(1)
+	task.timer = time.AfterFunc(delay, func() {
+		d.mx.Lock()
+		_, ok := d.tasks[task]
+		delete(d.tasks, task)
+		d.mx.Unlock()
+
+		if ok { // 未被 Close 取走
+			d.run(task)
+		}
+	})
+	return nil
+}
+
+func (d *delayDeleter) run(task *delayDelTask) {
+	defer d.wg.Done()
+
+	err := d.cache.del(context.Background(), task.keys...)
+	if err != nil {
+		d.cache.metrics.Err(opDelayDel)
+		logger.Log.Error("延迟删除失败", zap.String("cacheName", d.cache.cacheName), zap.Strings("keys", task.keys), zap.Error(err))
+	}
+}
+
+// 关闭, 立即执行所有未到期的删除并等待执行完毕
+func (d *delayDeleter) Close() {
+	d.mx.Lock()
+	if d.closed {
+		d.mx.Unlock()
+		return
+	}
+	d.closed = true
+	tasks := d.tasks
+	d.tasks = make(map[*delayDelTask]struct{})
+	d.mx.Unlock()
+
+	for task := range tasks {
+		task.timer.Stop()
+		d.run(task)
+	}
+	d.wg.Wait()
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// 依赖标签前缀, 依赖key的数据会被加入 depTagPrefix+依赖的key 标签
+const depTagPrefix = "__dep:"
+
+func depTag(key string) string {
+	return depTagPrefix + key
+}
+
+type depRecorderKey struct{}
+
+// 记录加载函数依赖的key
+type depRecorder struct {
+	cache *Cache
+
+	mx   sync.Mutex
+	keys []string
+	seen map[string]struct{}
+}
+
+// 为加载函数创建依赖记录器
+func (c *Cache) withDepRecorder(ctx context.Context) (context.Context, *depRecorder) {
+	r := &depRecorder{cache: c, seen: make(map[string]struct{})}
+	return context.WithValue(ctx, depRecorderKey{}, r), r
+}
+
+func (r *depRecorder) Add(keys ...string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	for _, key := range keys {
+		if _, ok := r.seen[key]; !ok {
+			r.seen[key] = struct{}{}
+			r.keys = append(r.keys, key)
+		}
+	}
+}
+
+// 获取依赖标签
+func (r *depRecorder) Tags() []string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	tags := make([]string, len(r.keys))
+	for i, key := range r.keys {
+		tags[i] = depTag(key)
+	}
+	return tags
+}
+
+// 在加载函数中声明依赖的key, 这些key被删除或更新时会删除加载函数写入的key. 只能依赖同一个cache中的key, 需要在配置中启用 EnableDependency
+func DependOn(ctx context.Context, keys ...string) {
+	if r, ok := ctx.Value(depRecorderKey{}).(*depRecorder); ok {
+		r.Add(keys...)
+	}
+}
+
+// 在加载函数中通过 Get 读取的key自动记录为依赖
+func (c *Cache) recordDep(ctx context.Context, key string) {
+	if r, ok := ctx.Value(depRecorderKey{}).(*depRecorder); ok && r.cache == c {
+		r.Add(key)
+	}
+}
+
+// 获取依赖keys的key
+func (c *Cache) dependents(ctx context.Context, key string) ([]string, error) {
+	if c.tagIndex != nil {
+		return c.tagIndex.Members(depTag(key)), nil
+	}
+	keys, err := c.dbTagMembers(ctx, depTag(key))
+	if err != nil {
+		return nil, fmt.Errorf("获取依赖key失败: %w", err)
+	}
+	return keys, nil
+}
+
+// 递归删除依赖keys的所有key
+func (c *Cache) delDependents(ctx context.Context, keys ...string) error {
+	visited := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		visited[key] = struct{}{}
+	}
+
+	queue := append([]string(nil), keys...)
+	for len(queue) > 0 {
+		key := queue[0]
+		queue = queue[1:]
+
+		members, err := c.dependents(ctx, key)
+		if err != nil {
+			return err
+		}
+		next := make([]string, 0, len(members))
+		for _, k := range members {
+			if _, ok := visited[k]; !ok {
+				visited[k] = struct{}{}
+				next = append(next, k)
+			}
+		}
+		if len(next) == 0 {
+			continue
+		}
+
+		if err = c.delKeys(ctx, next...); err != nil {
+			return err
+		}
+		if c.tagIndex == nil {
+			if err = c.dbRemoveFromTag(ctx, depTag(key), next); err != nil {
+				return fmt.Errorf("从标签中移除key失败: %w", err)
+			}
+		}
+		queue = append(queue, next...)
+	}
+	return nil
+}
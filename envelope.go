@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zly-app/zapp/pkg/compactor"
+	"github.com/zly-app/zapp/pkg/serializer"
+
+	"github.com/zly-app/cache/v2/core"
+	"github.com/zly-app/cache/v2/errs"
+)
+
+/*
+数据信封, 写入缓存数据库的数据由头部和压缩后的序列化数据组成, 多字节字段使用大端序
+
+	0  标识 0x00 0xcf. 没有信封的旧数据可能恰好以它开头, 所以读取时还会校验头部, 校验不通过时按旧数据处理
+	2  格式版本
+	3  序列化器id, 0 表示未知
+	4  压缩器id, 0 表示未知
+	5  标志位
+	6  写入时间的毫秒时间戳, 不能为 0
+	14 软过期时间的毫秒时间戳, 即写入时的有效期, 0 表示永不过期
+	22 硬过期时间的毫秒时间戳, 即最大存活时间, 0 表示不限制
+	30 数据结构版本
+	32 数据
+*/
+const (
+	envelopeVersion    = 1
+	envelopeHeaderSize = 32
+)
+
+var envelopeMagic = [2]byte{0x00, 0xcf}
+
+// 信封标志位
+const (
+	envelopeFlagNil = 1 << 0 // 数据为nil
+)
+
+// 序列化器和压缩器的id, 写入后不能修改
+var (
+	serializerIDs = map[string]byte{
+		serializer.SonicSerializerName:            1,
+		serializer.SonicStdSerializerName:         2,
+		serializer.MsgPackSerializerName:          3,
+		serializer.JsonIterSerializerName:         4,
+		serializer.JsonIterStandardSerializerName: 5,
+		serializer.JsonSerializerName:             6,
+		serializer.YamlSerializerName:             7,
+		serializer.BytesSerializerName:            8,
+	}
+	compactorIDs = map[string]byte{
+		compactor.RawCompactorName:  1,
+		compactor.ZStdCompactorName: 2,
+		compactor.GzipCompactorName: 3,
+	}
+
+	serializerNames, compactorNames     = reverseIDs(serializerIDs), reverseIDs(compactorIDs)
+	serializerTypeIDs, compactorTypeIDs = serializerTypes(), compactorTypes()
+	errUnsupportedEnvelope              = errors.New("不支持的数据格式版本")
+)
+
+func reverseIDs(ids map[string]byte) map[byte]string {
+	names := make(map[byte]string, len(ids))
+	for name, id := range ids {
+		names[id] = name
+	}
+	return names
+}
+
+// 通过类型识别没有名字的序列化器, 例如通过 WithSerializer 设置的序列化器
+func serializerTypes() map[string]byte {
+	types := make(map[string]byte, len(serializerIDs))
+	for name, id := range serializerIDs {
+		if s, ok := serializer.TryGetSerializer(name); ok {
+			types[fmt.Sprintf("%T", s)] = id
+		}
+	}
+	return types
+}
+
+func compactorTypes() map[string]byte {
+	types := make(map[string]byte, len(compactorIDs))
+	for name, id := range compactorIDs {
+		if c, ok := compactor.TryGetCompactor(name); ok {
+			types[fmt.Sprintf("%T", c)] = id
+		}
+	}
+	return types
+}
+
+func serializerID(name string, s core.ISerializer) byte {
+	if id, ok := serializerIDs[name]; ok {
+		return id
+	}
+	return serializerTypeIDs[fmt.Sprintf("%T", s)]
+}
+
+func compactorID(name string, c core.ICompactor) byte {
+	if id, ok := compactorIDs[name]; ok {
+		return id
+	}
+	return compactorTypeIDs[fmt.Sprintf("%T", c)]
+}
+
+// 数据信封的头部
+type envelope struct {
+	Version       byte
+	SerializerID  byte
+	CompactorID   byte
+	Flags         byte
+	SchemaVersion uint16
+	CreatedAt     time.Time
+	SoftExpire    time.Time
+	HardExpire    time.Time
+}
+
+func unixMilli(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixMilli())
+}
+
+func fromUnixMilli(ms uint64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(int64(ms))
+}
+
+// 编码数据信封
+func encodeEnvelope(env *envelope, payload []byte) []byte {
+	data := make([]byte, envelopeHeaderSize+len(payload))
+	copy(data, envelopeMagic[:])
+	data[2] = env.Version
+	data[3] = env.SerializerID
+	data[4] = env.CompactorID
+	data[5] = env.Flags
+	binary.BigEndian.PutUint64(data[6:], unixMilli(env.CreatedAt))
+	binary.BigEndian.PutUint64(data[14:], unixMilli(env.SoftExpire))
+	binary.BigEndian.PutUint64(data[22:], unixMilli(env.HardExpire))
+	binary.BigEndian.PutUint16(data[30:], env.SchemaVersion)
+	copy(data[envelopeHeaderSize:], payload)
+	return data
+}
+
+// 是否为数据信封, 除了标识外还会校验头部中的编解码器id, 标志位和时间戳
+func isEnvelope(bs []byte) bool {
+	if len(bs) < envelopeHeaderSize || bs[0] != envelopeMagic[0] || bs[1] != envelopeMagic[1] {
+		return false
+	}
+	if _, ok := serializerNames[bs[3]]; !ok && bs[3] != 0 {
+		return false
+	}
+	if _, ok := compactorNames[bs[4]]; !ok && bs[4] != 0 {
+		return false
+	}
+	if bs[5]&^envelopeFlagNil != 0 {
+		return false
+	}
+	createdAt := binary.BigEndian.Uint64(bs[6:])
+	softExpire := binary.BigEndian.Uint64(bs[14:])
+	hardExpire := binary.BigEndian.Uint64(bs[22:])
+	return createdAt != 0 && (softExpire == 0 || softExpire >= createdAt) && (hardExpire == 0 || hardExpire >= createdAt)
+}
+
+/*
+解码数据信封, 返回头部和数据.
+
+	没有信封或头部校验不通过的旧数据返回的头部为nil
+*/
+func decodeEnvelope(bs []byte) (*envelope, []byte, error) {
+	if !isEnvelope(bs) {
+		return nil, bs, nil
+	}
+	if bs[2] != envelopeVersion {
+		return nil, nil, errUnsupportedEnvelope
+	}
+	env := &envelope{
+		Version:       bs[2],
+		SerializerID:  bs[3],
+		CompactorID:   bs[4],
+		Flags:         bs[5],
+		CreatedAt:     fromUnixMilli(binary.BigEndian.Uint64(bs[6:])),
+		SoftExpire:    fromUnixMilli(binary.BigEndian.Uint64(bs[14:])),
+		HardExpire:    fromUnixMilli(binary.BigEndian.Uint64(bs[22:])),
+		SchemaVersion: binary.BigEndian.Uint16(bs[30:]),
+	}
+	return env, bs[envelopeHeaderSize:], nil
+}
+
+// 解析后的缓存数据, 每次读取只解析一次头部
+type cacheValue struct {
+	raw     []byte    // 缓存数据库中的原始数据
+	env     *envelope // 没有信封的旧数据为nil
+	payload []byte    // 压缩后的序列化数据
+}
+
+// 解析缓存数据
+func parseValue(key string, bs []byte) (*cacheValue, error) {
+	env, payload, err := decodeEnvelope(bs)
+	if err != nil {
+		return nil, &CodecError{Key: key, Stage: errs.StageEnvelope, Err: err}
+	}
+	return &cacheValue{raw: bs, env: env, payload: payload}, nil
+}
+
+// 数据的硬过期时间, 没有时返回零值
+func (v *cacheValue) hardExpire() time.Time {
+	if v.env == nil {
+		return time.Time{}
+	}
+	return v.env.HardExpire
+}
+
+// 获取信封中记录的编解码器, 未知时使用传入的编解码器
+func (env *envelope) codec(s core.ISerializer, c core.ICompactor) (core.ISerializer, core.ICompactor) {
+	if env == nil {
+		return s, c
+	}
+	if name, ok := serializerNames[env.SerializerID]; ok {
+		if v, ok := serializer.TryGetSerializer(name); ok {
+			s = v
+		}
+	}
+	if name, ok := compactorNames[env.CompactorID]; ok {
+		if v, ok := compactor.TryGetCompactor(name); ok {
+			c = v
+		}
+	}
+	return s, c
+}
+
+/*
+将编码后的数据装入信封, 返回写入缓存数据库的数据和有效期.
+
+	设置了最大存活时间时, 有效期不会超过最大存活时间
+*/
+func (c *Cache) wrapValue(payload []byte, ttl time.Duration, opt *options) ([]byte, time.Duration) {
+	now := time.Now()
+	env := &envelope{
+		Version:       envelopeVersion,
+		SerializerID:  serializerID(opt.SerializerName, opt.Serializer),
+		CompactorID:   compactorID(opt.CompactorName, opt.Compactor),
+		SchemaVersion: c.schemaVersion,
+		CreatedAt:     now,
+	}
+	if payload == nil {
+		env.Flags |= envelopeFlagNil
+	}
+	if c.maxLifetime > 0 {
+		if ttl <= 0 || ttl > c.maxLifetime {
+			ttl = c.maxLifetime
+		}
+		env.HardExpire = now.Add(c.maxLifetime)
+	}
+	if ttl > 0 {
+		env.SoftExpire = now.Add(ttl)
+	}
+	return encodeEnvelope(env, payload), ttl
+}
@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"time"
 
 	"github.com/zly-app/cache/v2/core"
 )
@@ -22,10 +23,46 @@ func (e errCache) SingleFlightDo(ctx context.Context, key string, aPtr interface
 	return e.err
 }
 
+func (e errCache) Save(ctx context.Context, key string, data interface{}, opts ...core.Option) error {
+	return e.err
+}
+
 func (e errCache) Del(ctx context.Context, keys ...string) error {
 	return e.err
 }
 
+func (e errCache) Exists(ctx context.Context, key string) (bool, error) {
+	return false, e.err
+}
+
+func (e errCache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return 0, e.err
+}
+
+func (e errCache) Touch(ctx context.Context, key string, expireSec int) error {
+	return e.err
+}
+
+func (e errCache) DelPrefix(ctx context.Context, prefix string) error {
+	return e.err
+}
+
+func (e errCache) Scan(ctx context.Context, prefix string, fn func(key string) bool) error {
+	return e.err
+}
+
+func (e errCache) Clear(ctx context.Context) error {
+	return e.err
+}
+
+func (e errCache) DelByTag(ctx context.Context, tag string) error {
+	return e.err
+}
+
+func (e errCache) DelWithDelay(ctx context.Context, delay time.Duration, keys ...string) error {
+	return e.err
+}
+
 func (e errCache) Close() error {
 	return e.err
 }
@@ -2,6 +2,8 @@ package errs
 
 import (
 	"errors"
+	"fmt"
+	"time"
 )
 
 // 查询缓存不存在应该返回这个错误
@@ -9,3 +11,92 @@ var CacheMiss = errors.New("cache miss")
 
 // 数据为nil
 var DataIsNil = errors.New("data is nil")
+
+// 熔断器已打开, 跳过缓存数据库
+var CircuitOpen = errors.New("circuit breaker is open")
+
+// 加载函数并发数已满, 排队超时
+var LoaderOverloaded = errors.New("loader overloaded")
+
+// 同一个key加载过于频繁
+var LoadTooFrequent = errors.New("load too frequent")
+
+// 延迟写入队列已满
+var WriteBehindQueueFull = errors.New("write behind queue is full")
+
+// cache已关闭
+var CacheClosed = errors.New("cache is closed")
+
+// 加载函数的错误已被缓存, 在 RetryAt 之前不会再调用加载函数
+type CachedLoadError struct {
+	Key     string
+	Err     error     // 加载函数返回的错误
+	RetryAt time.Time // 下次允许调用加载函数的时间
+}
+
+func (e *CachedLoadError) Error() string {
+	return fmt.Sprintf("cached load error, retry at %s: %v", e.RetryAt.Format("15:04:05.000"), e.Err)
+}
+
+func (e *CachedLoadError) Unwrap() error {
+	return e.Err
+}
+
+// 错误发生的阶段
+const (
+	StageLoad        = "load"        // 调用加载函数
+	StageGet         = "get"         // 从缓存数据库读取
+	StageSet         = "set"         // 写入缓存数据库
+	StageDel         = "del"         // 从缓存数据库删除
+	StageSerialize   = "serialize"   // 序列化
+	StageCompress    = "compress"    // 压缩
+	StageUncompress  = "uncompress"  // 解压缩
+	StageDeserialize = "deserialize" // 反序列化
+	StageEnvelope    = "envelope"    // 解析数据信封
+	StageUpcast      = "upcast"      // 升级数据结构版本
+)
+
+// 加载函数返回的错误
+type LoadError struct {
+	Key   string
+	Stage string
+	Err   error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("load error, key=%s, stage=%s: %v", e.Key, e.Stage, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// 缓存数据库故障, 批量操作时 Key 为第一个key
+type CacheFaultError struct {
+	Key   string
+	Stage string
+	Err   error
+}
+
+func (e *CacheFaultError) Error() string {
+	return fmt.Sprintf("cache fault, key=%s, stage=%s: %v", e.Key, e.Stage, e.Err)
+}
+
+func (e *CacheFaultError) Unwrap() error {
+	return e.Err
+}
+
+// 编解码失败
+type CodecError struct {
+	Key   string
+	Stage string
+	Err   error
+}
+
+func (e *CodecError) Error() string {
+	return fmt.Sprintf("codec error, key=%s, stage=%s: %v", e.Key, e.Stage, e.Err)
+}
+
+func (e *CodecError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zly-app/zapp/filter"
+	"github.com/zly-app/zapp/pkg/utils"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+// 缓存数据库不支持过期时间操作
+var errExpireNotSupported = errors.New("缓存数据库不支持过期时间操作")
+
+type touchReq struct {
+	Key    string
+	Expire time.Duration
+}
+
+func (c *Cache) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "Exists")
+	r := &key
+	rsp, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
+		r := req.(*string)
+		c.setSpanAttr(ctx, c.traceKeyAttr(*r), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		return c.dbExists(ctx, *r)
+	})
+	if err != nil {
+		return false, err
+	}
+	return rsp.(bool), nil
+}
+
+func (c *Cache) TTL(ctx context.Context, key string) (time.Duration, error) {
+	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "TTL")
+	r := &key
+	rsp, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
+		r := req.(*string)
+		c.setSpanAttr(ctx, c.traceKeyAttr(*r), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		return c.dbTTL(ctx, *r)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return rsp.(time.Duration), nil
+}
+
+func (c *Cache) Touch(ctx context.Context, key string, expireSec int) error {
+	ttl := time.Duration(expireSec) * time.Second
+	switch {
+	case expireSec == 0:
+		ttl = c.expire
+	case expireSec < 0:
+		ttl = 0
+	}
+	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "Touch")
+	r := &touchReq{Key: key, Expire: ttl}
+	_, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
+		r := req.(*touchReq)
+		c.setSpanAttr(ctx, c.traceKeyAttr(r.Key), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrExpireMs).Int64(r.Expire.Milliseconds()))
+		err := c.dbTouch(ctx, r.Key, r.Expire)
+		if err == nil && c.tagIndex != nil {
+			c.tagIndex.Touch(r.Key, r.Expire)
+		}
+		return nil, err
+	})
+	return err
+}
+
+func (c *Cache) expireDB() (core.IExpireCacheDB, error) {
+	e, ok := c.cacheDB.(core.IExpireCacheDB)
+	if _, supported := unwrapCacheDB(c.cacheDB).(core.IExpireCacheDB); !ok || !supported {
+		return nil, errExpireNotSupported
+	}
+	return e, nil
+}
+
+// 检查key是否存在, 不会读取数据也不会计入命中率
+func (c *Cache) dbExists(ctx context.Context, key string) (bool, error) {
+	e, err := c.expireDB()
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	err = c.dbDo(ctx, opExists, c.getTimeout, []string{key}, func(ctx context.Context) (err error) {
+		exists, err = e.Exists(ctx, c.dbKey(key))
+		return err
+	})
+	return exists, err
+}
+
+// 获取key剩余的有效期
+func (c *Cache) dbTTL(ctx context.Context, key string) (time.Duration, error) {
+	e, err := c.expireDB()
+	if err != nil {
+		return 0, err
+	}
+	var ttl time.Duration
+	err = c.dbDo(ctx, opTTL, c.getTimeout, []string{key}, func(ctx context.Context) (err error) {
+		ttl, err = e.TTL(ctx, c.dbKey(key))
+		return err
+	})
+	return ttl, err
+}
+
+// 修改key的有效期
+func (c *Cache) dbTouch(ctx context.Context, key string, ttl time.Duration) error {
+	e, err := c.expireDB()
+	if err != nil {
+		return err
+	}
+	return c.dbDo(ctx, opTouch, c.setTimeout, []string{key}, func(ctx context.Context) error {
+		return e.Touch(ctx, c.dbKey(key), ttl)
+	})
+}
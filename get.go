@@ -2,7 +2,8 @@ package cache
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"time"
 
 	"github.com/zly-app/zapp/filter"
 	"github.com/zly-app/zapp/logger"
@@ -10,12 +11,13 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/zly-app/cache/v2/core"
+	"github.com/zly-app/cache/v2/errs"
 )
 
 type getReq struct {
 	Key            string
 	opt            *options
-	ExpireSec      int
+	Expire         time.Duration
 	ForceLoad      bool // 忽略缓存从加载函数加载数据
 	DontWriteCache bool // 不要刷新到缓存
 }
@@ -28,7 +30,7 @@ func (c *Cache) Get(ctx context.Context, key string, aPtr interface{}, opts ...c
 	r := &getReq{
 		Key:            key,
 		opt:            opt,
-		ExpireSec:      opt.ExpireSec,
+		Expire:         opt.TTL(),
 		ForceLoad:      opt.ForceLoad,
 		DontWriteCache: opt.DontWriteCache,
 	}
@@ -37,77 +39,220 @@ func (c *Cache) Get(ctx context.Context, key string, aPtr interface{}, opts ...c
 		r := req.(*getReq)
 		sp := rsp
 
-		comData, err := c.getRaw(ctx, r.Key, r.opt)
+		c.setSpanAttr(ctx, c.traceKeyAttr(r.Key), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		c.setSpanAttr(ctx, r.opt.MakeTraceAttr()...)
+		if c.enableDependency {
+			c.recordDep(ctx, r.Key)
+		}
+
+		v, hit, err := c.getRaw(ctx, r.Key, r.opt)
+		if err == nil && hit {
+			v, err = c.upcast(ctx, r.Key, v, r.opt)
+		}
 		if err == nil {
-			err = c.unmarshalQuery(comData, sp, r.opt.Serializer, r.opt.Compactor)
+			c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrValueSize).Int(len(v.raw)))
+			err = c.decodeValue(r.Key, v, sp, r.opt.Serializer, r.opt.Compactor)
+		}
+		if err != nil && hit && c.decodeErrorAsMiss && isDecodeError(err) {
+			err = c.healDecodeError(ctx, r.Key, sp, r.opt, err)
 		}
 		return err
 	})
 	return err
 }
-func (c *Cache) getRaw(ctx context.Context, key string, opt *options) ([]byte, error) {
-	var bs []byte
+
+/*
+获取并解析数据, 缓存未命中时通过加载函数加载, 返回的 hit 表示数据是否来自缓存.
+
+	缓存中的数据头部无法解析时视为命中并返回解码错误, 由调用方决定是否修复
+*/
+func (c *Cache) getRaw(ctx context.Context, key string, opt *options) (v *cacheValue, hit bool, err error) {
 	cacheErr := ErrCacheMiss
 	if !opt.ForceLoad {
-		bs, cacheErr = c.cacheDB.Get(ctx, key)
+		v, cacheErr = c.getWithSliding(ctx, key, opt)
 	}
 
-	if cacheErr == nil {
-		return bs, nil
+	if cacheErr == nil || isDecodeError(cacheErr) {
+		c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrResult).String(traceResultHit))
+		c.listener.Emit(opt.Listeners, func(l core.IListener) { l.OnHit(ctx, key) })
+		return v, true, cacheErr
 	}
 
-	if cacheErr == ErrCacheMiss {
+	switch cacheErr {
+	case ErrCacheMiss:
+		c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrResult).String(traceResultMiss))
 		utils.Otel.CtxEvent(ctx, "CacheMiss")
-	} else {
+		c.listener.Emit(opt.Listeners, func(l core.IListener) { l.OnMiss(ctx, key) })
+	case ErrCircuitOpen: // 熔断器已打开, 忽略缓存故障时跳过缓存直接加载
+		c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrResult).String(traceResultBypass))
+		utils.Otel.CtxEvent(ctx, "CircuitOpen")
+	default:
+		c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrResult).String(traceResultFault))
 		utils.Otel.CtxErrEvent(ctx, "GetCacheErr", cacheErr)
+		faultErr := cacheErr
+		c.listener.Emit(opt.Listeners, func(l core.IListener) { l.OnError(ctx, key, faultErr) })
 	}
 
-	if cacheErr != ErrCacheMiss { // 缓存故障
-		if c.ignoreCacheFault {
+	if cacheErr != ErrCacheMiss { // 缓存故障, 熔断器打开也视为缓存故障
+		if c.ignoreCacheFault && cacheErr != ErrCircuitOpen {
 			logger.Log.Error("从缓存数据库加载数据故障", zap.String("key", key), zap.Error(cacheErr))
 		}
-		cacheErr = fmt.Errorf("从缓存数据库加载数据故障: err: %v", cacheErr)
+		cacheErr = &CacheFaultError{Key: key, Stage: errs.StageGet, Err: cacheErr}
 		if !c.ignoreCacheFault { // 如果不忽略缓存故障则直接报告错误
-			return nil, cacheErr
+			return nil, false, cacheErr
 		}
 	}
 	if opt.LoadFn == nil {
-		return nil, cacheErr
+		return nil, false, cacheErr
 	}
 
 	// 加载数据
-	bs, err := c.sf.Do(ctx, key, c.load(opt))
+	bs, err := c.sfDo(ctx, key, opt)
+	if err != nil {
+		return nil, false, err
+	}
+	v, err = parseValue(key, bs)
+	return v, false, err
+}
+
+// 通过单跑模块加载数据
+func (c *Cache) sfDo(ctx context.Context, key string, opt *options) ([]byte, error) {
+	c.metrics.IncSFWaiter()
+	isLeader := false
+	load := c.load(opt)
+	bs, err := c.sf.Do(ctx, key, func(ctx context.Context, key string) ([]byte, error) {
+		// 真正执行加载的不是等待者
+		isLeader = true
+		c.metrics.DecSFWaiter()
+		return load(ctx, key)
+	})
+	if !isLeader {
+		c.metrics.DecSFWaiter()
+	}
 	return bs, err
 }
 
 func (c *Cache) load(opt *options) core.LoadInvoke {
 	return func(ctx context.Context, key string) (bs []byte, err error) {
+		ctx = c.startSpan(ctx, "cache/Load", key)
+		defer func() { c.endSpan(ctx, err) }()
+
 		err = utils.Recover.WrapCall(func() error {
+			// 退避期间直接返回缓存的加载错误
+			if err := c.loadErrCache.Get(key); err != nil {
+				utils.Otel.CtxEvent(ctx, "LoadErrorCached")
+				return &LoadError{Key: key, Stage: errs.StageLoad, Err: err}
+			}
+
+			// 获取加载名额
+			release, err := c.acquireLoad(ctx, key)
+			if err != nil {
+				c.metrics.LoaderReject()
+				return &LoadError{Key: key, Stage: errs.StageLoad, Err: err}
+			}
+			c.loadLimiter.attempted(key)
+
+			// 获取写入令牌, 必须在加载数据前获取
+			var token *writeToken
+			if !opt.DontWriteCache {
+				token = c.acquireWriteToken(ctx, key)
+				defer c.releaseWriteToken(token)
+			}
+
 			// 加载数据
-			data, err := opt.LoadFn(ctx, key)
+			loadCtx, deps := ctx, (*depRecorder)(nil)
+			if c.enableDependency {
+				loadCtx, deps = c.withDepRecorder(ctx)
+			}
+			startTime := time.Now()
+			data, err := func() (interface{}, error) {
+				defer release()
+				return opt.LoadFn(loadCtx, key)
+			}()
+			c.metrics.ObserveLoad(startTime)
+			loadErr := err
+			c.listener.Emit(opt.Listeners, func(l core.IListener) { l.OnLoad(ctx, key, loadErr) })
 			if err != nil {
-				return fmt.Errorf("从加载函数加载数据失败: %v", err)
+				c.metrics.Err(opLoad)
+				c.loadErrCache.Fail(key, err)
+				return &LoadError{Key: key, Stage: errs.StageLoad, Err: err}
 			}
+			c.loadErrCache.Reset(key)
+
+			data, ttl, expireAt, dontCache := c.parseLoadResult(data, opt)
 
 			// 编码数据
-			bs, err = c.marshalQuery(data, opt.Serializer, opt.Compactor)
+			bs, err = c.marshalQuery(key, data, opt.Serializer, opt.Compactor)
 			if err != nil {
-				return fmt.Errorf("编码数据失败: %v", err)
+				return err
 			}
 
+			// 装入信封, 等待者使用信封中记录的编解码器解码
+			bs, ttl = c.wrapValue(bs, ttl, opt)
+
 			// 写入缓存
-			if opt.DontWriteCache {
+			if opt.DontWriteCache || dontCache {
+				return nil
+			}
+			cacheErr := c.setWithToken(ctx, key, token, bs, ttl, expireAt)
+			if cacheErr == nil {
+				tags := opt.Tags
+				if deps != nil {
+					tags = append(deps.Tags(), tags...)
+				}
+				cacheErr = c.addTags(ctx, key, ttl, tags)
+			}
+			if cacheErr == errStaleWrite { // 加载期间key被删除, 跳过写入
+				c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrResult).String(traceResultStale))
+				return nil
+			}
+			if errors.Is(cacheErr, ErrCircuitOpen) { // 熔断器已打开, 跳过写入
 				return nil
 			}
-			cacheErr := c.cacheDB.Set(ctx, key, bs, opt.ExpireSec)
+			c.listener.Emit(opt.Listeners, func(l core.IListener) { l.OnSet(ctx, key, cacheErr) })
 			if cacheErr != nil {
+				c.listener.Emit(opt.Listeners, func(l core.IListener) { l.OnError(ctx, key, cacheErr) })
 				if !c.ignoreCacheFault {
-					return fmt.Errorf("写入缓存失败: %v", cacheErr)
+					return &CacheFaultError{Key: key, Stage: errs.StageSet, Err: cacheErr}
 				}
-				logger.Log.Error("写入缓存失败", zap.String("key", key), zap.Error(err))
+				logger.Log.Error("写入缓存失败", zap.String("key", key), zap.Error(cacheErr))
 			}
 			return nil
 		})
 		return bs, err
 	}
 }
+
+/*
+解析加载函数返回的数据, 返回数据, 写入缓存的有效期, 截止时间和是否不写入缓存.
+
+	加载结果没有设置有效期时使用本次调用的有效期, 已经过了 WithExpireAt 设置的过期时间时不写入缓存
+*/
+func (c *Cache) parseLoadResult(data interface{}, opt *options) (interface{}, time.Duration, time.Time, bool) {
+	ttl, expireAt := opt.TTL(), c.writeDeadline(opt)
+	dontCache := opt.Expired()
+	var result *LoadResult
+	switch v := data.(type) {
+	case LoadResult:
+		result = &v
+	case *LoadResult:
+		if v == nil { // 按nil数据处理
+			data = nil
+		}
+		result = v
+	}
+	if result != nil {
+		data = result.Value
+		if result.TTL != 0 { // 加载结果设置了有效期, 不使用本次调用的过期时间
+			ttl, expireAt, dontCache = result.TTL, time.Time{}, false
+			if ttl < 0 {
+				ttl = 0
+			}
+		}
+		dontCache = dontCache || result.DontCache
+	}
+	if !dontCache && opt.CacheIf != nil {
+		dontCache = !opt.CacheIf(data)
+	}
+	return data, ttl, expireAt, dontCache
+}
@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	hedgeSampleSize     = 1000 // 保留的耗时样本数
+	hedgeRefreshEvery   = 100  // 每多少个样本重新计算一次p95
+	hedgeMinSampleCount = 100  // 样本数达到多少后才使用p95
+)
+
+// 对冲读, 为nil时表示不启用
+type hedger struct {
+	minDelay time.Duration
+
+	mx      sync.Mutex
+	samples []time.Duration
+	idx     int
+	count   int
+	p95     int64 // 原子操作, time.Duration
+}
+
+func newHedger(conf *Config) *hedger {
+	if !conf.Hedge.Enable {
+		return nil
+	}
+	return &hedger{
+		minDelay: time.Duration(conf.Hedge.MinDelayMs) * time.Millisecond,
+		samples:  make([]time.Duration, hedgeSampleSize),
+	}
+}
+
+// 记录一次Get耗时
+func (h *hedger) Observe(d time.Duration) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+
+	h.samples[h.idx] = d
+	h.idx = (h.idx + 1) % len(h.samples)
+	h.count++
+	if h.count < hedgeMinSampleCount || h.count%hedgeRefreshEvery != 0 {
+		return
+	}
+
+	n := h.count
+	if n > len(h.samples) {
+		n = len(h.samples)
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, h.samples[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	atomic.StoreInt64(&h.p95, int64(sorted[n*95/100]))
+}
+
+// 获取发起对冲请求前的等待时间
+func (h *hedger) Delay() time.Duration {
+	d := time.Duration(atomic.LoadInt64(&h.p95))
+	if d < h.minDelay {
+		return h.minDelay
+	}
+	return d
+}
+
+type hedgeResult struct {
+	bs  []byte
+	err error
+}
+
+// 对冲读, 第一个请求在p95耗时内未返回时发起第二个请求, 使用先返回的成功结果
+func (c *Cache) hedgedGet(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	get := func() {
+		startTime := time.Now()
+		bs, err := c.cacheDB.Get(ctx, key)
+		if err == nil || err == ErrCacheMiss {
+			c.hedge.Observe(time.Since(startTime))
+		}
+		results <- hedgeResult{bs, err}
+	}
+	go get()
+
+	timer := time.NewTimer(c.hedge.Delay())
+	defer timer.Stop()
+
+	inflight, hedged := 1, false
+	for {
+		select {
+		case r := <-results:
+			inflight--
+			if r.err == nil || r.err == ErrCacheMiss || inflight == 0 {
+				return r.bs, r.err
+			}
+		case <-timer.C:
+			if !hedged {
+				hedged = true
+				inflight++
+				c.metrics.Hedge(opGet)
+				go get()
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package metricsreg
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/zly-app/zapp/component/metrics"
+)
+
+// 一组指标及注册它的metrics客户端
+type entry struct {
+	client metrics.Client
+	set    interface{}
+}
+
+/*
+按metrics客户端注册的指标, 每个metrics客户端只注册一次.
+
+	上报时才注册并且会跟随metrics客户端的变化, 在设置metrics客户端前创建的组件也能正常上报
+*/
+type Registry struct {
+	newSet func(client metrics.Client) interface{}
+
+	cur     atomic.Value // *entry, 当前metrics客户端的指标
+	mx      sync.Mutex
+	entries []*entry
+}
+
+// newSet 在指定的metrics客户端上注册一组指标
+func New(newSet func(client metrics.Client) interface{}) *Registry {
+	return &Registry{newSet: newSet}
+}
+
+// 获取当前metrics客户端的指标, 未注册时注册
+func (r *Registry) Get() interface{} {
+	client := metrics.GetClient()
+	if e, _ := r.cur.Load().(*entry); e != nil && sameClient(e.client, client) {
+		return e.set
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, e := range r.entries {
+		if sameClient(e.client, client) {
+			r.cur.Store(e)
+			return e.set
+		}
+	}
+	e := &entry{client: client, set: r.newSet(client)}
+	r.entries = append(r.entries, e)
+	r.cur.Store(e)
+	return e.set
+}
+
+// 是否为同一个metrics客户端, 不可比较的客户端视为不同
+func sameClient(a, b metrics.Client) bool {
+	t := reflect.TypeOf(a)
+	return t == reflect.TypeOf(b) && t != nil && t.Comparable() && a == b
+}
@@ -0,0 +1,36 @@
+package metricsreg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/zly-app/zapp/component/metrics"
+)
+
+type testClient struct {
+	metrics.Client
+	id int
+}
+
+func TestRegistry(t *testing.T) {
+	old := metrics.GetClient()
+	defer metrics.SetClient(old)
+
+	var registered []metrics.Client
+	r := New(func(client metrics.Client) interface{} {
+		registered = append(registered, client)
+		return len(registered)
+	})
+
+	a, b := &testClient{id: 1}, &testClient{id: 2}
+	metrics.SetClient(a)
+	require.Equal(t, 1, r.Get())
+	require.Equal(t, 1, r.Get())
+
+	// 跟随metrics客户端的变化, 每个客户端只注册一次
+	metrics.SetClient(b)
+	require.Equal(t, 2, r.Get())
+	metrics.SetClient(a)
+	require.Equal(t, 1, r.Get())
+	require.Equal(t, []metrics.Client{a, b}, registered)
+}
@@ -0,0 +1,61 @@
+package invalidation
+
+const (
+	defBatchSize       = 100
+	defFlushIntervalMs = 50
+	defWorkers         = 4
+	defQueueSize       = 1000
+	defAttempts        = 3
+	defBackoffMs       = 100
+	defMaxBackoffMs    = 2000
+	defEnableMetrics   = true
+)
+
+type Config struct {
+	BatchSize       int  // 每次批量删除的最大key数量
+	FlushIntervalMs int  // 批量删除的最大等待毫秒数
+	Workers         int  // 删除协程数, 同一个key总是由同一个协程按顺序删除
+	QueueSize       int  // 每个删除协程的队列大小, 队列满时阻塞读取事件
+	Attempts        int  // 删除失败时的最大尝试次数
+	BackoffMs       int  // 首次重试前的等待毫秒数, 之后每次翻倍
+	MaxBackoffMs    int  // 最大等待毫秒数
+	EnableMetrics   bool // 是否启用指标上报, 包括删除延迟和失败数
+}
+
+func NewConfig() *Config {
+	return &Config{
+		BatchSize:       defBatchSize,
+		FlushIntervalMs: defFlushIntervalMs,
+		Workers:         defWorkers,
+		QueueSize:       defQueueSize,
+		Attempts:        defAttempts,
+		BackoffMs:       defBackoffMs,
+		MaxBackoffMs:    defMaxBackoffMs,
+		EnableMetrics:   defEnableMetrics,
+	}
+}
+
+func (conf *Config) Check() error {
+	if conf.BatchSize < 1 {
+		conf.BatchSize = defBatchSize
+	}
+	if conf.FlushIntervalMs < 1 {
+		conf.FlushIntervalMs = defFlushIntervalMs
+	}
+	if conf.Workers < 1 {
+		conf.Workers = defWorkers
+	}
+	if conf.QueueSize < 1 {
+		conf.QueueSize = defQueueSize
+	}
+	if conf.Attempts < 1 {
+		conf.Attempts = defAttempts
+	}
+	if conf.BackoffMs < 1 {
+		conf.BackoffMs = defBackoffMs
+	}
+	if conf.MaxBackoffMs < conf.BackoffMs {
+		conf.MaxBackoffMs = conf.BackoffMs
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package invalidation
+
+import (
+	"time"
+)
+
+// 数据变更事件
+type Event struct {
+	Table     string            // 表名
+	Fields    map[string]string // 变更后的字段值, 删除时为删除前的字段值
+	OldFields map[string]string // 变更前的字段值, 可选, 用于key中的字段被修改的情况
+	Time      time.Time         // 变更发生时间, 用于计算延迟, 为零值时不上报延迟
+	Ack       func(err error)   // 可选, 事件对应的所有key处理完毕后调用, err为第一个失败的错误, 可用于提交消费位点
+}
+
+// 事件源
+type ISource interface {
+	// 返回事件通道, 通道关闭表示事件源结束
+	Events() <-chan *Event
+}
+
+type chanSource <-chan *Event
+
+func (s chanSource) Events() <-chan *Event { return s }
+
+// 将通道包装为事件源
+func ChanSource(ch <-chan *Event) ISource {
+	return chanSource(ch)
+}
@@ -0,0 +1,296 @@
+package invalidation
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/zly-app/zapp/logger"
+	"go.uber.org/zap"
+)
+
+// 删除缓存, cache.ICache 实现了该接口
+type IDeleter interface {
+	Del(ctx context.Context, keys ...string) error
+}
+
+// 跟踪一个事件的所有key是否处理完毕
+type eventTracker struct {
+	ack func(err error)
+
+	mx      sync.Mutex
+	pending int
+	err     error
+}
+
+func newEventTracker(ack func(err error), pending int) *eventTracker {
+	if ack == nil {
+		return nil
+	}
+	return &eventTracker{ack: ack, pending: pending}
+}
+
+func (t *eventTracker) Done(err error) {
+	if t == nil {
+		return
+	}
+	t.mx.Lock()
+	if t.err == nil {
+		t.err = err
+	}
+	t.pending--
+	done := t.pending == 0
+	err = t.err
+	t.mx.Unlock()
+
+	if done {
+		t.ack(err)
+	}
+}
+
+type delItem struct {
+	key       string
+	table     string
+	eventTime time.Time
+	tracker   *eventTracker
+}
+
+// 失效器, 根据数据变更事件删除缓存
+type Invalidator struct {
+	name    string
+	deleter IDeleter
+	conf    *Config
+	metrics *invalidationMetrics
+
+	mx        sync.RWMutex
+	templates map[string][]*keyTemplate // 表名 -> key模板
+}
+
+func NewInvalidator(name string, deleter IDeleter, conf *Config) (*Invalidator, error) {
+	if conf == nil {
+		conf = NewConfig()
+	}
+	if err := conf.Check(); err != nil {
+		return nil, fmt.Errorf("invalidation配置检查失败: %w", err)
+	}
+	return &Invalidator{
+		name:      name,
+		deleter:   deleter,
+		conf:      conf,
+		metrics:   newInvalidationMetrics(name, conf.EnableMetrics),
+		templates: make(map[string][]*keyTemplate),
+	}, nil
+}
+
+// 注册表对应的key模板, 模板中 {field} 会被替换为事件中的字段值, 如 user:{id}
+func (i *Invalidator) Register(table string, templates ...string) error {
+	ts := make([]*keyTemplate, 0, len(templates))
+	for _, raw := range templates {
+		t, err := parseTemplate(raw)
+		if err != nil {
+			return err
+		}
+		ts = append(ts, t)
+	}
+
+	i.mx.Lock()
+	i.templates[table] = append(i.templates[table], ts...)
+	i.mx.Unlock()
+	return nil
+}
+
+// 根据事件生成需要删除的key
+func (i *Invalidator) keys(e *Event) ([]string, error) {
+	i.mx.RLock()
+	ts := i.templates[e.Table]
+	i.mx.RUnlock()
+
+	keys := make([]string, 0, len(ts))
+	seen := make(map[string]struct{}, len(ts))
+	add := func(fields map[string]string) error {
+		for _, t := range ts {
+			key, err := t.Render(fields)
+			if err != nil {
+				return err
+			}
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+		return nil
+	}
+	if err := add(e.Fields); err != nil {
+		return nil, err
+	}
+	if e.OldFields != nil {
+		// 变更前的字段值通常只包含被修改的字段, 缺少的字段使用变更后的值
+		old := make(map[string]string, len(e.Fields)+len(e.OldFields))
+		for k, v := range e.Fields {
+			old[k] = v
+		}
+		for k, v := range e.OldFields {
+			old[k] = v
+		}
+		if err := add(old); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+/*
+消费事件源直到事件源结束或ctx结束, 返回前会删除已分发的所有key.
+
+	ctx结束时事件中未分发的key不会被删除, 该事件的 Ack 会收到ctx的错误
+*/
+func (i *Invalidator) Run(ctx context.Context, source ISource) error {
+	queues := make([]chan *delItem, i.conf.Workers)
+	var wg sync.WaitGroup
+	for n := range queues {
+		queues[n] = make(chan *delItem, i.conf.QueueSize)
+		wg.Add(1)
+		go func(queue chan *delItem) {
+			defer wg.Done()
+			i.worker(queue)
+		}(queues[n])
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	events := source.Events()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if e == nil {
+				continue
+			}
+			if err := i.dispatch(ctx, e, queues); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// 将事件的key分发到删除协程, 同一个key总是分发到同一个协程. 只在ctx结束时返回错误
+func (i *Invalidator) dispatch(ctx context.Context, e *Event, queues []chan *delItem) error {
+	keys, err := i.keys(e)
+	if err != nil {
+		i.metrics.Err(e.Table)
+		logger.Log.Error("生成失效key失败", zap.String("invalidator", i.name), zap.String("table", e.Table), zap.Error(err))
+		if e.Ack != nil {
+			e.Ack(err)
+		}
+		return nil
+	}
+	if len(keys) == 0 {
+		if e.Ack != nil {
+			e.Ack(nil)
+		}
+		return nil
+	}
+
+	tracker := newEventTracker(e.Ack, len(keys))
+	for n, key := range keys {
+		f := fnv.New32a()
+		_, _ = f.Write([]byte(key))
+		item := &delItem{
+			key:       key,
+			table:     e.Table,
+			eventTime: e.Time,
+			tracker:   tracker,
+		}
+		select {
+		case queues[f.Sum32()%uint32(len(queues))] <- item:
+		case <-ctx.Done():
+			for range keys[n:] {
+				tracker.Done(ctx.Err())
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (i *Invalidator) worker(queue chan *delItem) {
+	interval := time.Duration(i.conf.FlushIntervalMs) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	batch := make([]*delItem, 0, i.conf.BatchSize)
+	for {
+		select {
+		case item, ok := <-queue:
+			if !ok {
+				i.flush(batch)
+				return
+			}
+			batch = append(batch, item)
+			if len(batch) >= i.conf.BatchSize {
+				i.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				i.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// 批量删除
+func (i *Invalidator) flush(batch []*delItem) {
+	if len(batch) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(batch))
+	seen := make(map[string]struct{}, len(batch))
+	for _, item := range batch {
+		if _, ok := seen[item.key]; !ok {
+			seen[item.key] = struct{}{}
+			keys = append(keys, item.key)
+		}
+	}
+
+	// 删除不随ctx取消, 保证已读取的事件都被处理
+	ctx := context.Background()
+	backoff := time.Duration(i.conf.BackoffMs) * time.Millisecond
+	maxBackoff := time.Duration(i.conf.MaxBackoffMs) * time.Millisecond
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = i.deleter.Del(ctx, keys...)
+		if err == nil || attempt >= i.conf.Attempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+	if err != nil {
+		logger.Log.Error("删除失效key失败", zap.String("invalidator", i.name), zap.Strings("keys", keys), zap.Error(err))
+	}
+
+	for _, item := range batch {
+		if err != nil {
+			i.metrics.Err(item.table)
+		} else {
+			i.metrics.Deleted(item.table, item.eventTime)
+		}
+		item.tracker.Done(err)
+	}
+}
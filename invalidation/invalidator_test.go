@@ -0,0 +1,124 @@
+package invalidation
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// 测试用的删除器, 记录删除的key
+type testDeleter struct {
+	mx      sync.Mutex
+	keys    []string
+	release chan struct{} // 不为nil时删除会阻塞到通道关闭
+}
+
+func (d *testDeleter) Del(ctx context.Context, keys ...string) error {
+	if d.release != nil {
+		<-d.release
+	}
+	d.mx.Lock()
+	d.keys = append(d.keys, keys...)
+	d.mx.Unlock()
+	return nil
+}
+
+func (d *testDeleter) Deleted() []string {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	keys := append([]string(nil), d.keys...)
+	sort.Strings(keys)
+	return keys
+}
+
+func newTestInvalidator(t *testing.T, deleter IDeleter, conf *Config) *Invalidator {
+	if conf == nil {
+		conf = NewConfig()
+	}
+	conf.EnableMetrics = false
+	inv, err := NewInvalidator("test", deleter, conf)
+	require.Nil(t, err)
+	require.Nil(t, inv.Register("user", "user:{id}", "user:name:{name}"))
+	return inv
+}
+
+func TestInvalidator(t *testing.T) {
+	deleter := &testDeleter{}
+	inv := newTestInvalidator(t, deleter, nil)
+
+	ackErr := make(chan error, 1)
+	events := make(chan *Event, 1)
+	events <- &Event{
+		Table:     "user",
+		Fields:    map[string]string{"id": "1", "name": "b"},
+		OldFields: map[string]string{"id": "1", "name": "a"},
+		Time:      time.Now(),
+		Ack:       func(err error) { ackErr <- err },
+	}
+	close(events)
+
+	err := inv.Run(context.Background(), ChanSource(events))
+	require.Nil(t, err)
+	require.Nil(t, <-ackErr)
+	require.Equal(t, []string{"user:1", "user:name:a", "user:name:b"}, deleter.Deleted())
+}
+
+func TestInvalidatorPartialOldFields(t *testing.T) {
+	inv := newTestInvalidator(t, &testDeleter{}, nil)
+
+	// 变更前的字段值只包含被修改的字段
+	keys, err := inv.keys(&Event{
+		Table:     "user",
+		Fields:    map[string]string{"id": "1", "name": "b"},
+		OldFields: map[string]string{"name": "a"},
+	})
+	require.Nil(t, err)
+	sort.Strings(keys)
+	require.Equal(t, []string{"user:1", "user:name:a", "user:name:b"}, keys)
+
+	// 变更后的字段值缺少模板字段时返回错误
+	_, err = inv.keys(&Event{Table: "user", Fields: map[string]string{"id": "1"}})
+	require.NotNil(t, err)
+}
+
+func TestInvalidatorCancel(t *testing.T) {
+	deleter := &testDeleter{release: make(chan struct{})}
+	conf := NewConfig()
+	conf.Workers = 1
+	conf.QueueSize = 1
+	conf.BatchSize = 1
+	inv := newTestInvalidator(t, deleter, conf)
+
+	// 事件的key数量超过队列容量, 删除阻塞时分发也会阻塞
+	templates := make([]string, 10)
+	for n := range templates {
+		templates[n] = "order:{id}:" + strconv.Itoa(n)
+	}
+	require.Nil(t, inv.Register("order", templates...))
+
+	ackErr := make(chan error, 1)
+	events := make(chan *Event, 1)
+	events <- &Event{
+		Table:  "order",
+		Fields: map[string]string{"id": "1"},
+		Ack:    func(err error) { ackErr <- err },
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- inv.Run(ctx, ChanSource(events)) }()
+
+	// ctx结束后停止分发, 已分发的key删除完毕后返回
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	close(deleter.release)
+	require.Equal(t, context.Canceled, <-done)
+	require.Equal(t, context.Canceled, <-ackErr)
+	require.Less(t, len(deleter.Deleted()), len(templates))
+}
@@ -0,0 +1,82 @@
+package invalidation
+
+import (
+	"time"
+
+	"github.com/zly-app/zapp/component/metrics"
+
+	"github.com/zly-app/cache/v2/internal/metricsreg"
+)
+
+const (
+	metricsInvalidationLagMsec  = "cache_invalidation_lag_msec"  // 从变更发生到删除缓存的延迟桶
+	metricsInvalidationKeyTotal = "cache_invalidation_key_total" // 删除key计数器
+	metricsInvalidationErrTotal = "cache_invalidation_err_total" // 失败计数器
+)
+
+const (
+	labelInvalidator = "invalidator"
+	labelTable       = "table"
+)
+
+// 失效器的一组指标
+type metricsSet struct {
+	lagMsec  metrics.IHistogram
+	keyTotal metrics.ICounter
+	errTotal metrics.ICounter
+}
+
+var metricsRegistry = metricsreg.New(func(client metrics.Client) interface{} { return newMetricsSet(client) })
+
+func newMetricsSet(client metrics.Client) *metricsSet {
+	labels := []string{labelInvalidator, labelTable}
+	lagBuckets := []float64{10, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000, 60000}
+	return &metricsSet{
+		lagMsec:  client.RegistryHistogram(metricsInvalidationLagMsec, "从变更发生到删除缓存的延迟桶", lagBuckets, nil, labels...),
+		keyTotal: client.RegistryCounter(metricsInvalidationKeyTotal, "删除key计数器", nil, labels...),
+		errTotal: client.RegistryCounter(metricsInvalidationErrTotal, "失败计数器", nil, labels...),
+	}
+}
+
+// 指标上报, 为nil时表示不上报
+type invalidationMetrics struct {
+	name string
+}
+
+func newInvalidationMetrics(name string, enable bool) *invalidationMetrics {
+	if !enable {
+		return nil
+	}
+	return &invalidationMetrics{name: name}
+}
+
+// 获取当前metrics客户端的指标
+func (m *invalidationMetrics) get() *metricsSet {
+	return metricsRegistry.Get().(*metricsSet)
+}
+
+func (m *invalidationMetrics) labels(table string) metrics.Labels {
+	return metrics.Labels{
+		labelInvalidator: m.name,
+		labelTable:       table,
+	}
+}
+
+// 删除成功
+func (m *invalidationMetrics) Deleted(table string, eventTime time.Time) {
+	if m == nil {
+		return
+	}
+	m.get().keyTotal.Inc(m.labels(table), nil)
+	if !eventTime.IsZero() {
+		m.get().lagMsec.Observe(float64(time.Since(eventTime)/time.Millisecond), m.labels(table), nil)
+	}
+}
+
+// 失败
+func (m *invalidationMetrics) Err(table string) {
+	if m == nil {
+		return
+	}
+	m.get().errTotal.Inc(m.labels(table), nil)
+}
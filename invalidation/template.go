@@ -0,0 +1,60 @@
+package invalidation
+
+import (
+	"fmt"
+	"strings"
+)
+
+type templatePart struct {
+	literal string
+	field   string // 不为空时表示字段
+}
+
+// key模板, 如 user:{id}
+type keyTemplate struct {
+	raw   string
+	parts []templatePart
+}
+
+func parseTemplate(raw string) (*keyTemplate, error) {
+	t := &keyTemplate{raw: raw}
+	s := raw
+	for len(s) > 0 {
+		start := strings.IndexByte(s, '{')
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: s})
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: s[:start]})
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("key模板<%s>缺少 }", raw)
+		}
+		field := s[start+1 : start+end]
+		if field == "" {
+			return nil, fmt.Errorf("key模板<%s>中存在空字段", raw)
+		}
+		t.parts = append(t.parts, templatePart{field: field})
+		s = s[start+end+1:]
+	}
+	return t, nil
+}
+
+// 使用字段值生成key
+func (t *keyTemplate) Render(fields map[string]string) (string, error) {
+	var b strings.Builder
+	for _, p := range t.parts {
+		if p.field == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		v, ok := fields[p.field]
+		if !ok {
+			return "", fmt.Errorf("key模板<%s>的字段<%s>不存在", t.raw, p.field)
+		}
+		b.WriteString(v)
+	}
+	return b.String(), nil
+}
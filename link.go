@@ -43,9 +43,41 @@ var (
 	ErrCacheMiss = errs.CacheMiss
 	// 数据为nil
 	ErrDataIsNil = errs.DataIsNil
+	// 熔断器已打开, 跳过缓存数据库
+	ErrCircuitOpen = errs.CircuitOpen
+	// 加载函数并发数已满, 排队超时
+	ErrLoaderOverloaded = errs.LoaderOverloaded
+	// 同一个key加载过于频繁
+	ErrLoadTooFrequent = errs.LoadTooFrequent
+	// 延迟写入队列已满
+	ErrWriteBehindQueueFull = errs.WriteBehindQueueFull
+	// cache已关闭
+	ErrCacheClosed = errs.CacheClosed
 )
 
+// 错误类型, 可以通过 errors.As 判断
 type (
-	ICache = core.ICache
-	LoadFn = core.LoadFn
+	// 加载函数返回的错误
+	LoadError = errs.LoadError
+	// 加载函数的错误已被缓存
+	CachedLoadError = errs.CachedLoadError
+	// 缓存数据库故障
+	CacheFaultError = errs.CacheFaultError
+	// 编解码失败
+	CodecError = errs.CodecError
+)
+
+// TTL 返回该值表示永不过期
+const NoExpire = core.NoExpire
+
+type (
+	ICache     = core.ICache
+	LoadFn     = core.LoadFn
+	LoadResult = core.LoadResult
+	SaveFn     = core.SaveFn
+	Upcaster   = core.Upcaster
+
+	IListener    = core.IListener
+	NoopListener = core.NoopListener
+	EvictReason  = core.EvictReason
 )
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/zly-app/zapp/logger"
+	"github.com/zly-app/zapp/pkg/utils"
+	"go.uber.org/zap"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+// 事件分发器
+type listenerHub struct {
+	cacheName string
+	listeners []core.IListener
+	async     bool
+	queue     chan func()
+
+	mx     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newListenerHub(cacheName string, listeners []core.IListener, async bool, queueSize int) *listenerHub {
+	h := &listenerHub{
+		cacheName: cacheName,
+		listeners: listeners,
+		async:     async,
+	}
+	if async {
+		h.queue = make(chan func(), queueSize)
+		h.wg.Add(1)
+		go h.loop()
+	}
+	return h
+}
+
+func (h *listenerHub) loop() {
+	defer h.wg.Done()
+	for fn := range h.queue {
+		fn()
+	}
+}
+
+// 投递事件, extra 为本次调用额外设置的监听器
+func (h *listenerHub) Emit(extra []core.IListener, fn func(l core.IListener)) {
+	if len(h.listeners) == 0 && len(extra) == 0 {
+		return
+	}
+
+	listeners := h.listeners
+	if len(extra) > 0 {
+		listeners = make([]core.IListener, 0, len(h.listeners)+len(extra))
+		listeners = append(listeners, h.listeners...)
+		listeners = append(listeners, extra...)
+	}
+	call := func() {
+		for _, l := range listeners {
+			err := utils.Recover.WrapCall(func() error {
+				fn(l)
+				return nil
+			})
+			if err != nil {
+				logger.Log.Error("cache事件监听器执行失败", zap.String("cacheName", h.cacheName), zap.Error(err))
+			}
+		}
+	}
+
+	if !h.async {
+		call()
+		return
+	}
+
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	if h.closed {
+		return
+	}
+	select {
+	case h.queue <- call:
+	default:
+		logger.Log.Warn("cache事件队列已满, 丢弃事件", zap.String("cacheName", h.cacheName))
+	}
+}
+
+// 关闭, 会等待队列中的事件投递完毕
+func (h *listenerHub) Close() {
+	if !h.async {
+		return
+	}
+	h.mx.Lock()
+	if h.closed {
+		h.mx.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.queue)
+	h.mx.Unlock()
+
+	h.wg.Wait()
+}
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// 加载错误缓存, 为nil时表示不缓存
+type loadErrCache struct {
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mx      sync.Mutex
+	entries map[string]*loadErrEntry
+	nextGC  time.Time
+}
+
+type loadErrEntry struct {
+	err     error
+	fails   int       // 连续失败次数
+	retryAt time.Time // 下次允许加载的时间
+}
+
+func newLoadErrCache(conf *Config) *loadErrCache {
+	if !conf.ErrorCache.Enable {
+		return nil
+	}
+	return &loadErrCache{
+		backoff:    time.Duration(conf.ErrorCache.BackoffMs) * time.Millisecond,
+		maxBackoff: time.Duration(conf.ErrorCache.MaxBackoffMs) * time.Millisecond,
+		entries:    make(map[string]*loadErrEntry),
+	}
+}
+
+// 获取key在退避期间缓存的错误, 不在退避期间返回nil
+func (e *loadErrCache) Get(key string) error {
+	if e == nil {
+		return nil
+	}
+
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	entry, ok := e.entries[key]
+	if !ok || !time.Now().Before(entry.retryAt) {
+		return nil
+	}
+	return &CachedLoadError{Key: key, Err: entry.err, RetryAt: entry.retryAt}
+}
+
+// 记录key加载失败, 退避时间随连续失败次数翻倍. 调用方取消或超时导致的失败不记录
+func (e *loadErrCache) Fail(key string, err error) {
+	if e == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	now := time.Now()
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	entry, ok := e.entries[key]
+	if !ok || now.Sub(entry.retryAt) >= e.maxBackoff { // 距离上次失败太久, 重新开始退避
+		entry = &loadErrEntry{}
+		e.entries[key] = entry
+	}
+	entry.err = err
+	entry.fails++
+	backoff := e.backoff
+	for i := 1; i < entry.fails && backoff < e.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > e.maxBackoff {
+		backoff = e.maxBackoff
+	}
+	entry.retryAt = now.Add(backoff)
+
+	// 定期清理不再需要的记录
+	if now.After(e.nextGC) {
+		for k, v := range e.entries {
+			if now.Sub(v.retryAt) >= e.maxBackoff {
+				delete(e.entries, k)
+			}
+		}
+		e.nextGC = now.Add(e.maxBackoff)
+	}
+}
+
+// 加载成功, 重置key的退避
+func (e *loadErrCache) Reset(key string) {
+	if e == nil {
+		return
+	}
+
+	e.mx.Lock()
+	delete(e.entries, key)
+	e.mx.Unlock()
+}
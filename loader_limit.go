@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 加载并发限制器
+type loadSemaphore chan struct{}
+
+func newLoadSemaphore(maxConcurrency int) loadSemaphore {
+	if maxConcurrency < 1 {
+		return nil
+	}
+	return make(loadSemaphore, maxConcurrency)
+}
+
+// 获取加载名额, 在 timer 或 ctx 结束前未获取到返回 false
+func (s loadSemaphore) acquire(ctx context.Context, timer <-chan time.Time) bool {
+	if s == nil {
+		return true
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+	}
+	select {
+	case s <- struct{}{}:
+		return true
+	case <-timer:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s loadSemaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// 全局加载并发限制, 对所有cache生效
+var globalLoadSemaphore atomic.Value
+
+// 设置全局加载函数最大并发数, 对所有cache生效, maxConcurrency < 1 表示不限制. 应该在使用cache前设置
+func SetGlobalLoaderLimit(maxConcurrency int) {
+	globalLoadSemaphore.Store(newLoadSemaphore(maxConcurrency))
+}
+
+func getGlobalLoadSemaphore() loadSemaphore {
+	s, _ := globalLoadSemaphore.Load().(loadSemaphore)
+	return s
+}
+
+// 加载限制器, 为nil时表示不限制
+type loadLimiter struct {
+	sem         loadSemaphore
+	minInterval time.Duration
+
+	mx       sync.Mutex
+	lastLoad map[string]time.Time // key最后一次开始加载的时间
+	nextGC   time.Time
+}
+
+func newLoadLimiter(conf *Config) *loadLimiter {
+	if conf.Loader.MaxConcurrency < 1 && conf.Loader.MinIntervalMs < 1 {
+		return nil
+	}
+	l := &loadLimiter{
+		sem:         newLoadSemaphore(conf.Loader.MaxConcurrency),
+		minInterval: time.Duration(conf.Loader.MinIntervalMs) * time.Millisecond,
+	}
+	if l.minInterval > 0 {
+		l.lastLoad = make(map[string]time.Time)
+	}
+	return l
+}
+
+// 检查key距离上次开始加载是否超过最小间隔
+func (l *loadLimiter) allowKey(key string) bool {
+	if l == nil || l.minInterval <= 0 {
+		return true
+	}
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	last, ok := l.lastLoad[key]
+	return !ok || time.Since(last) >= l.minInterval
+}
+
+// 记录key开始加载的时间, 加载失败同样记录, 避免一直失败的加载函数不受限制
+func (l *loadLimiter) attempted(key string) {
+	if l == nil || l.minInterval <= 0 {
+		return
+	}
+
+	now := time.Now()
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	l.lastLoad[key] = now
+
+	// 定期清理过期的记录
+	if now.After(l.nextGC) {
+		for k, t := range l.lastLoad {
+			if now.Sub(t) >= l.minInterval {
+				delete(l.lastLoad, k)
+			}
+		}
+		l.nextGC = now.Add(l.minInterval)
+	}
+}
+
+// 获取加载名额, 先获取全局名额再获取cache名额. 返回的释放函数不为nil时必须调用
+func (c *Cache) acquireLoad(ctx context.Context, key string) (func(), error) {
+	if !c.loadLimiter.allowKey(key) {
+		return nil, ErrLoadTooFrequent
+	}
+
+	global := getGlobalLoadSemaphore()
+	var local loadSemaphore
+	if c.loadLimiter != nil {
+		local = c.loadLimiter.sem
+	}
+	if global == nil && local == nil {
+		return func() {}, nil
+	}
+
+	// 排队超时对全局名额和cache名额都生效, 未启用cache加载限制时同样生效
+	var timer <-chan time.Time
+	if c.loadQueueTimeout > 0 {
+		t := time.NewTimer(c.loadQueueTimeout)
+		defer t.Stop()
+		timer = t.C
+	}
+	if !global.acquire(ctx, timer) {
+		return nil, ErrLoaderOverloaded
+	}
+	if !local.acquire(ctx, timer) {
+		global.release()
+		return nil, ErrLoaderOverloaded
+	}
+	return func() {
+		local.release()
+		global.release()
+	}, nil
+}
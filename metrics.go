@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/zly-app/zapp/component/metrics"
+
+	"github.com/zly-app/cache/v2/internal/metricsreg"
+)
+
+const (
+	metricsCacheHitTotal      = "cache_hit_total"            // 缓存命中计数器
+	metricsCacheMissTotal     = "cache_miss_total"           // 缓存未命中计数器
+	metricsCacheErrTotal      = "cache_err_total"            // 错误计数器
+	metricsCacheLoadMsec      = "cache_load_msec"            // 加载函数耗时桶
+	metricsCacheDBMsec        = "cache_db_msec"              // 缓存数据库操作耗时桶
+	metricsCacheSFWaitersSize = "cache_sf_waiters_size"      // SingleFlight等待者数量
+	metricsCacheBreakerState  = "cache_breaker_state"        // 熔断器状态, 0关闭, 1打开, 2半开
+	metricsCacheBreakerReject = "cache_breaker_reject_total" // 熔断器拒绝访问缓存数据库计数器
+	metricsCacheHedgeTotal    = "cache_hedge_total"          // 对冲请求计数器
+	metricsCacheRetryTotal    = "cache_retry_total"          // 重试计数器
+	metricsCacheLoaderReject  = "cache_loader_reject_total"  // 加载函数限流计数器
+	metricsCacheDecodeErr     = "cache_decode_err_total"     // 缓存数据解码失败计数器
+)
+
+const (
+	labelCacheName = "cache_name"
+	labelOperation = "operation"
+	labelBackend   = "backend"
+)
+
+const (
+	opGet  = "Get"
+	opSet  = "Set"
+	opDel  = "Del"
+	opLoad = "Load"
+	opSave = "Save"
+
+	opDelayDel = "DelayDel"
+
+	opGetVersion    = "GetVersion"
+	opAddTags       = "AddTags"
+	opTagMembers    = "TagMembers"
+	opRemoveFromTag = "RemoveFromTag"
+	opScan          = "Scan"
+	opDelPrefix     = "DelPrefix"
+	opClear         = "Clear"
+	opExists        = "Exists"
+	opTTL           = "TTL"
+	opTouch         = "Touch"
+
+	opCircuitBreaker = "CircuitBreaker"
+)
+
+// 缓存的一组指标
+type metricsSet struct {
+	hitTotal      metrics.ICounter
+	missTotal     metrics.ICounter
+	errTotal      metrics.ICounter
+	loadMsec      metrics.IHistogram
+	dbMsec        metrics.IHistogram
+	sfWaitersSize metrics.IGauge
+	breakerState  metrics.IGauge
+	breakerReject metrics.ICounter
+	hedgeTotal    metrics.ICounter
+	retryTotal    metrics.ICounter
+	loaderReject  metrics.ICounter
+	decodeErr     metrics.ICounter
+}
+
+var metricsRegistry = metricsreg.New(func(client metrics.Client) interface{} { return newMetricsSet(client) })
+
+func newMetricsSet(client metrics.Client) *metricsSet {
+	labels := []string{labelCacheName, labelOperation, labelBackend}
+	loadBuckets := []float64{10, 20, 30, 50, 100, 200, 300, 500, 1000, 2000, 3000, 5000}
+	dbBuckets := []float64{1, 2, 3, 5, 10, 20, 30, 50, 100, 200, 500, 1000}
+	return &metricsSet{
+		hitTotal:      client.RegistryCounter(metricsCacheHitTotal, "缓存命中计数器", nil, labels...),
+		missTotal:     client.RegistryCounter(metricsCacheMissTotal, "缓存未命中计数器", nil, labels...),
+		errTotal:      client.RegistryCounter(metricsCacheErrTotal, "错误计数器", nil, labels...),
+		loadMsec:      client.RegistryHistogram(metricsCacheLoadMsec, "加载函数耗时桶", loadBuckets, nil, labels...),
+		dbMsec:        client.RegistryHistogram(metricsCacheDBMsec, "缓存数据库操作耗时桶", dbBuckets, nil, labels...),
+		sfWaitersSize: client.RegistryGauge(metricsCacheSFWaitersSize, "SingleFlight等待者数量", nil, labels...),
+		breakerState:  client.RegistryGauge(metricsCacheBreakerState, "熔断器状态", nil, labels...),
+		breakerReject: client.RegistryCounter(metricsCacheBreakerReject, "熔断器拒绝访问缓存数据库计数器", nil, labels...),
+		hedgeTotal:    client.RegistryCounter(metricsCacheHedgeTotal, "对冲请求计数器", nil, labels...),
+		retryTotal:    client.RegistryCounter(metricsCacheRetryTotal, "重试计数器", nil, labels...),
+		loaderReject:  client.RegistryCounter(metricsCacheLoaderReject, "加载函数限流计数器", nil, labels...),
+		decodeErr:     client.RegistryCounter(metricsCacheDecodeErr, "缓存数据解码失败计数器", nil, labels...),
+	}
+}
+
+// 缓存指标上报, 为nil时表示不上报
+type cacheMetrics struct {
+	cacheName string
+	backend   string
+}
+
+func newCacheMetrics(cacheName, backend string, enable bool) *cacheMetrics {
+	if !enable {
+		return nil
+	}
+	return &cacheMetrics{
+		cacheName: cacheName,
+		backend:   backend,
+	}
+}
+
+// 获取当前metrics客户端的指标
+func (m *cacheMetrics) get() *metricsSet {
+	return metricsRegistry.Get().(*metricsSet)
+}
+
+func (m *cacheMetrics) labels(op string) metrics.Labels {
+	return metrics.Labels{
+		labelCacheName: m.cacheName,
+		labelOperation: op,
+		labelBackend:   m.backend,
+	}
+}
+
+// 命中
+func (m *cacheMetrics) Hit(op string) {
+	if m == nil {
+		return
+	}
+	m.get().hitTotal.Inc(m.labels(op), nil)
+}
+
+// 未命中
+func (m *cacheMetrics) Miss(op string) {
+	if m == nil {
+		return
+	}
+	m.get().missTotal.Inc(m.labels(op), nil)
+}
+
+// 出现错误
+func (m *cacheMetrics) Err(op string) {
+	if m == nil {
+		return
+	}
+	m.get().errTotal.Inc(m.labels(op), nil)
+}
+
+// 记录加载函数耗时
+func (m *cacheMetrics) ObserveLoad(startTime time.Time) {
+	if m == nil {
+		return
+	}
+	m.get().loadMsec.Observe(float64(time.Since(startTime)/time.Millisecond), m.labels(opLoad), nil)
+}
+
+// 记录缓存数据库操作耗时
+func (m *cacheMetrics) ObserveDB(op string, startTime time.Time) {
+	if m == nil {
+		return
+	}
+	m.get().dbMsec.Observe(float64(time.Since(startTime)/time.Millisecond), m.labels(op), nil)
+}
+
+// 增加SingleFlight等待者
+func (m *cacheMetrics) IncSFWaiter() {
+	if m == nil {
+		return
+	}
+	m.get().sfWaitersSize.Inc(m.labels(opLoad))
+}
+
+// 减少SingleFlight等待者
+func (m *cacheMetrics) DecSFWaiter() {
+	if m == nil {
+		return
+	}
+	m.get().sfWaitersSize.Dec(m.labels(opLoad))
+}
+
+// 设置熔断器状态
+func (m *cacheMetrics) SetBreakerState(state breakerState) {
+	if m == nil {
+		return
+	}
+	m.get().breakerState.Set(float64(state), m.labels(opCircuitBreaker))
+}
+
+// 熔断器拒绝访问缓存数据库
+func (m *cacheMetrics) BreakerReject(op string) {
+	if m == nil {
+		return
+	}
+	m.get().breakerReject.Inc(m.labels(op), nil)
+}
+
+// 发起对冲请求
+func (m *cacheMetrics) Hedge(op string) {
+	if m == nil {
+		return
+	}
+	m.get().hedgeTotal.Inc(m.labels(op), nil)
+}
+
+// 重试
+func (m *cacheMetrics) Retry(op string) {
+	if m == nil {
+		return
+	}
+	m.get().retryTotal.Inc(m.labels(op), nil)
+}
+
+// 加载函数被限流
+func (m *cacheMetrics) LoaderReject() {
+	if m == nil {
+		return
+	}
+	m.get().loaderReject.Inc(m.labels(opLoad), nil)
+}
+
+// 缓存数据解码失败
+func (m *cacheMetrics) DecodeErr(op string) {
+	if m == nil {
+		return
+	}
+	m.get().decodeErr.Inc(m.labels(op), nil)
+}
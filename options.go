@@ -1,7 +1,9 @@
 package cache
 
 import (
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/zly-app/zapp/pkg/utils"
 
@@ -12,15 +14,57 @@ var optionsPool = sync.Pool{New: func() interface{} { return &options{} }}
 
 type options struct {
 	Serializer     core.ISerializer
+	SerializerName string
 	Compactor      core.ICompactor
-	ExpireSec      int
+	CompactorName  string
+	Expire         time.Duration // 有效期, < 0 表示永不过期
+	ExpireAt       time.Time     // 过期时间, 不为零值时优先于 Expire
 	LoadFn         LoadFn
 	ForceLoad      bool // 忽略缓存从加载函数加载数据
 	DontWriteCache bool // 不要刷新到缓存
+	Listeners      []core.IListener
+	SaveFn         SaveFn
+	WriteBehind    bool // 延迟写入
+	Tags           []string
+	SlidingExpire  bool                     // 命中时重置有效期
+	CacheIf        func(v interface{}) bool // 加载的数据是否写入缓存
 }
 
 func (o *options) MakeTraceAttr() []utils.OtelSpanKV {
-	return nil
+	serializerName := o.SerializerName
+	if serializerName == "" {
+		serializerName = fmt.Sprintf("%T", o.Serializer)
+	}
+	compactorName := o.CompactorName
+	if compactorName == "" {
+		compactorName = fmt.Sprintf("%T", o.Compactor)
+	}
+	return []utils.OtelSpanKV{
+		utils.OtelSpanKey(traceAttrSerializer).String(serializerName),
+		utils.OtelSpanKey(traceAttrCompactor).String(compactorName),
+		utils.OtelSpanKey(traceAttrExpireMs).Int64(o.TTL().Milliseconds()),
+		utils.OtelSpanKey(traceAttrForceLoad).Bool(o.ForceLoad),
+	}
+}
+
+// 获取写入缓存数据库的有效期, <= 0 表示永不过期
+func (o *options) TTL() time.Duration {
+	if !o.ExpireAt.IsZero() {
+		ttl := time.Until(o.ExpireAt)
+		if ttl < time.Millisecond { // 已过期, 写入前应该先通过 Expired 检查
+			ttl = time.Millisecond
+		}
+		return ttl
+	}
+	if o.Expire < 0 {
+		return 0
+	}
+	return o.Expire
+}
+
+// 是否已经过了 WithExpireAt 设置的过期时间, 已过期的数据不应写入缓存
+func (o *options) Expired() bool {
+	return !o.ExpireAt.IsZero() && time.Until(o.ExpireAt) < time.Millisecond
 }
 
 func getOptions() *options {
@@ -28,27 +72,39 @@ func getOptions() *options {
 }
 func putOptions(opt *options) {
 	opt.Serializer = nil
+	opt.SerializerName = ""
 	opt.Compactor = nil
-	opt.ExpireSec = 0
+	opt.CompactorName = ""
+	opt.Expire = 0
+	opt.ExpireAt = time.Time{}
 	opt.LoadFn = nil
 	opt.ForceLoad = false
 	opt.DontWriteCache = false
+	opt.Listeners = nil
+	opt.SaveFn = nil
+	opt.WriteBehind = false
+	opt.Tags = nil
+	opt.SlidingExpire = false
+	opt.CacheIf = nil
 	optionsPool.Put(opt)
 }
 
 func (c *Cache) newOptions(opts []core.Option) *options {
 	opt := getOptions()
+	opt.SlidingExpire = c.slidingExpire
 	for _, o := range opts {
 		o(opt)
 	}
 	if opt.Serializer == nil {
 		opt.Serializer = c.serializer
+		opt.SerializerName = c.serializerName
 	}
 	if opt.Compactor == nil {
 		opt.Compactor = c.compactor
+		opt.CompactorName = c.compactorName
 	}
-	if opt.ExpireSec == 0 {
-		opt.ExpireSec = c.expireSec
+	if opt.Expire == 0 && opt.ExpireAt.IsZero() {
+		opt.Expire = c.expire
 	}
 	return opt
 }
@@ -69,8 +125,31 @@ func WithCompactor(compactor core.ICompactor) core.Option {
 
 // 设置有效期, expireSec < 0 表示永不过期, expireSec = 0 表示使用默认值
 func WithExpire(expireSec int) core.Option {
+	return WithTTL(time.Duration(expireSec) * time.Second)
+}
+
+// 设置有效期, 精确到毫秒, ttl < 0 表示永不过期, ttl = 0 表示使用默认值
+func WithTTL(ttl time.Duration) core.Option {
 	return func(opts interface{}) {
-		opts.(*options).ExpireSec = expireSec
+		opt := opts.(*options)
+		opt.Expire = ttl
+		opt.ExpireAt = time.Time{}
+	}
+}
+
+// 设置过期时间, 有效期在写入时根据过期时间计算, 如果过期时间已过则数据会立即过期
+func WithExpireAt(t time.Time) core.Option {
+	return func(opts interface{}) {
+		opt := opts.(*options)
+		opt.Expire = 0
+		opt.ExpireAt = t
+	}
+}
+
+// 设置是否启用滑动过期, 启用后命中时会将有效期重置为本次调用的有效期, 不设置时使用配置 SlidingExpire.Enable
+func WithSlidingExpire(enable bool) core.Option {
+	return func(opts interface{}) {
+		opts.(*options).SlidingExpire = enable
 	}
 }
 
@@ -81,6 +160,13 @@ func WithLoadFn(fn LoadFn) core.Option {
 	}
 }
 
+// 设置加载的数据是否写入缓存, fn 返回 false 时不写入, 可用于避免缓存空数据或不完整的数据
+func WithCacheIf(fn func(v interface{}) bool) core.Option {
+	return func(opts interface{}) {
+		opts.(*options).CacheIf = fn
+	}
+}
+
 // 忽略缓存从加载函数加载数据
 func WithForceLoad(dontWriteCache bool) core.Option {
 	return func(opts interface{}) {
@@ -89,3 +175,37 @@ func WithForceLoad(dontWriteCache bool) core.Option {
 		opt.DontWriteCache = dontWriteCache
 	}
 }
+
+// 添加本次调用的事件监听器, 会在cache的监听器之后调用
+func WithListener(listeners ...core.IListener) core.Option {
+	return func(opts interface{}) {
+		opt := opts.(*options)
+		opt.Listeners = append(opt.Listeners, listeners...)
+	}
+}
+
+// 设置保存数据函数, Save 时会调用它持久化数据
+func WithSaveFn(fn SaveFn) core.Option {
+	return func(opts interface{}) {
+		opts.(*options).SaveFn = fn
+	}
+}
+
+/*
+延迟写入, Save 时先写入缓存, 然后在后台调用 SaveFn 持久化. 同一个key的多次写入会被合并, 需要在配置中启用 WriteBehind.
+
+	SaveFn 收到的是传给 Save 的数据本身, Save 返回后不能再修改数据
+*/
+func WithWriteBehind() core.Option {
+	return func(opts interface{}) {
+		opts.(*options).WriteBehind = true
+	}
+}
+
+// 设置标签, 写入缓存时会将key加入这些标签, 之后可以通过 DelByTag 删除标签下的所有key. 可用于 Set, Save 和加载函数写入缓存
+func WithTags(tags ...string) core.Option {
+	return func(opts interface{}) {
+		opt := opts.(*options)
+		opt.Tags = append(opt.Tags, tags...)
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/zly-app/zapp/pkg/utils"
+)
+
+// 缓存数据库重试策略, 为nil时表示不重试
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+	retryable  func(err error) bool
+	random     func() float64 // 返回 [0, 1) 的随机数, 用于计算抖动
+}
+
+func newRetryPolicy(conf *Config) *retryPolicy {
+	if conf.Retry.Attempts < 2 {
+		return nil
+	}
+	return &retryPolicy{
+		attempts:   conf.Retry.Attempts,
+		backoff:    time.Duration(conf.Retry.BackoffMs) * time.Millisecond,
+		maxBackoff: time.Duration(conf.Retry.MaxBackoffMs) * time.Millisecond,
+		jitter:     conf.Retry.Jitter,
+		retryable:  conf.Retry.Retryable,
+		random:     rand.Float64,
+	}
+}
+
+// 默认的可重试错误判断, 未命中, 熔断和主动取消不重试
+func defRetryable(err error) bool {
+	return err != nil && err != ErrCacheMiss && err != ErrCircuitOpen && !errors.Is(err, context.Canceled)
+}
+
+func (r *retryPolicy) isRetryable(err error) bool {
+	if !defRetryable(err) {
+		return false
+	}
+	if r.retryable != nil {
+		return r.retryable(err)
+	}
+	return true
+}
+
+// 获取加上抖动后的等待时间
+func (r *retryPolicy) wait(backoff time.Duration) time.Duration {
+	if r.jitter <= 0 {
+		return backoff
+	}
+	delta := float64(backoff) * r.jitter
+	return backoff + time.Duration(delta*(r.random()*2-1))
+}
+
+// 执行缓存数据库操作, 失败时按照重试策略重试, 每次尝试单独计算超时
+func (c *Cache) doWithRetry(ctx context.Context, op string, timeout time.Duration, fn func(ctx context.Context) error) error {
+	r := c.retry
+	var backoff time.Duration
+	if r != nil {
+		backoff = r.backoff
+	}
+	for attempt := 1; ; attempt++ {
+		timeoutCtx, cancel := withTimeout(ctx, timeout)
+		err := fn(timeoutCtx)
+		cancel()
+
+		if r == nil || attempt >= r.attempts || !r.isRetryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		wait := r.wait(backoff)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= wait {
+			return err // 剩余时间不足以重试
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+
+		backoff *= 2
+		if backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+		c.metrics.Retry(op)
+		utils.Otel.CtxEvent(ctx, "Retry", utils.OtelSpanKey("attempt").Int(attempt+1), utils.OtelSpanKey("err").String(err.Error()))
+	}
+}
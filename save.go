@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/zly-app/zapp/filter"
+	"github.com/zly-app/zapp/logger"
+	"github.com/zly-app/zapp/pkg/utils"
+	"go.uber.org/zap"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+type saveReq struct {
+	Key         string
+	Data        interface{}
+	opt         *options
+	Expire      time.Duration
+	WriteBehind bool
+}
+
+func (c *Cache) Save(ctx context.Context, key string, data interface{}, opts ...core.Option) error {
+	opt := c.newOptions(opts)
+	defer putOptions(opt)
+
+	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "Save")
+	r := &saveReq{
+		Key:         key,
+		Data:        data,
+		opt:         opt,
+		Expire:      opt.TTL(),
+		WriteBehind: opt.WriteBehind,
+	}
+	_, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
+		r := req.(*saveReq)
+		c.setSpanAttr(ctx, c.traceKeyAttr(r.Key), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		c.setSpanAttr(ctx, r.opt.MakeTraceAttr()...)
+
+		err := c.save(ctx, r.Key, r.Data, r.opt)
+		return nil, err
+	})
+	return err
+}
+
+func (c *Cache) save(ctx context.Context, key string, data interface{}, opt *options) error {
+	if opt.SaveFn == nil {
+		return errors.New("SaveFn is nil")
+	}
+
+	bs, err := c.marshalQuery(key, data, opt.Serializer, opt.Compactor)
+	if err != nil {
+		return err
+	}
+
+	// 延迟写入, 先写入缓存再放入队列, 写入缓存失败时不会持久化
+	if opt.WriteBehind {
+		if c.writeBehind == nil {
+			return errors.New("WriteBehind is not enabled")
+		}
+		err = c.set(ctx, key, bs, opt)
+		if err != nil {
+			return err
+		}
+		err = c.writeBehind.Push(key, data, opt.SaveFn, opt.Listeners)
+		if err != nil {
+			// 放入队列失败时数据不会被持久化, 删除缓存避免缓存中保留未持久化的数据
+			if delErr := c.del(ctx, key); delErr != nil {
+				logger.Log.Error("放入延迟写入队列失败后删除缓存失败", zap.String("key", key), zap.Error(delErr))
+			}
+			return err
+		}
+		return nil
+	}
+
+	// 直写, 先持久化再写入缓存
+	err = utils.Recover.WrapCall(func() error {
+		return opt.SaveFn(ctx, key, data)
+	})
+	if err != nil {
+		c.metrics.Err(opSave)
+		return fmt.Errorf("保存数据失败: %w", err)
+	}
+	err = c.set(ctx, key, bs, opt)
+	if err != nil {
+		// 避免缓存中保留旧数据
+		if delErr := c.del(ctx, key); delErr != nil {
+			logger.Log.Error("写入缓存失败后删除缓存失败", zap.String("key", key), zap.Error(delErr))
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"context"
+	"errors"
+
+	"github.com/zly-app/zapp/filter"
+	"github.com/zly-app/zapp/pkg/utils"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+// 缓存数据库不支持遍历
+var errScanNotSupported = errors.New("缓存数据库不支持遍历")
+
+// 按前缀删除并更新版本时每次删除的key数量
+const delPrefixBatchSize = 1000
+
+type scanReq struct {
+	Prefix string
+}
+
+func (c *Cache) Scan(ctx context.Context, prefix string, fn func(key string) bool) error {
+	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "Scan")
+	r := &scanReq{Prefix: prefix}
+	_, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
+		r := req.(*scanReq)
+		c.setSpanAttr(ctx, c.traceKeyAttr(r.Prefix), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		err := c.dbScan(ctx, r.Prefix, fn)
+		return nil, err
+	})
+	return err
+}
+
+func (c *Cache) DelPrefix(ctx context.Context, prefix string) error {
+	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "DelPrefix")
+	r := &scanReq{Prefix: prefix}
+	_, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
+		r := req.(*scanReq)
+		c.setSpanAttr(ctx, c.traceKeyAttr(r.Prefix), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		err := c.delPrefix(ctx, r.Prefix)
+		return nil, err
+	})
+	return err
+}
+
+func (c *Cache) Clear(ctx context.Context) error {
+	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "Clear")
+	_, err := chain.Handle(ctx, nil, func(ctx context.Context, req interface{}) (interface{}, error) {
+		c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		// 设置了key前缀时只删除该前缀下的key, 避免影响共用缓存数据库的其它cache
+		if c.keyPrefix != "" {
+			return nil, c.delPrefix(ctx, "")
+		}
+		if err := c.checkClear(); err != nil {
+			return nil, err
+		}
+		err := c.dbClear(ctx)
+		if err == nil {
+			c.resetLocalIndex("")
+		}
+		return nil, err
+	})
+	return err
+}
+
+func (c *Cache) delPrefix(ctx context.Context, prefix string) error {
+	if c.dbKey(prefix) == "" {
+		if err := c.checkClear(); err != nil {
+			return err
+		}
+	}
+	if c.staleWriteProtect && c.leases == nil {
+		if err := c.delPrefixAndBumpVersion(ctx, prefix); err != nil {
+			return err
+		}
+	}
+	err := c.dbDelPrefix(ctx, prefix)
+	if err == nil {
+		c.resetLocalIndex(prefix)
+	}
+	return err
+}
+
+/*
+逐个删除前缀为prefix的key并更新版本, 避免删除前开始的加载写入旧数据. 用于支持版本控制的缓存数据库.
+
+	遍历完成后再分批删除, 避免在遍历的重试中嵌套删除的重试
+*/
+func (c *Cache) delPrefixAndBumpVersion(ctx context.Context, prefix string) error {
+	var keys []string
+	err := c.dbScan(ctx, prefix, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > delPrefixBatchSize {
+			n = delPrefixBatchSize
+		}
+		if err = c.dbDelAndBumpVersion(ctx, keys[:n]...); err != nil {
+			return err
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
+
+// 删除本地索引中前缀为prefix的key
+func (c *Cache) resetLocalIndex(prefix string) {
+	if c.tagIndex != nil {
+		c.tagIndex.RemovePrefix(prefix)
+	}
+	if c.leases != nil {
+		c.leases.InvalidatePrefix(prefix)
+	}
+}
+
+// 检查缓存数据库是否允许清空整个数据库, 包装的缓存数据库以被包装的为准
+func (c *Cache) checkClear() error {
+	if g, ok := unwrapCacheDB(c.cacheDB).(core.IClearGuardCacheDB); ok {
+		return g.CheckClear()
+	}
+	return nil
+}
+
+func (c *Cache) scanDB() (core.IScanCacheDB, error) {
+	s, ok := c.cacheDB.(core.IScanCacheDB)
+	if _, supported := unwrapCacheDB(c.cacheDB).(core.IScanCacheDB); !ok || !supported {
+		return nil, errScanNotSupported
+	}
+	return s, nil
+}
+
+// 遍历key, 不限制超时
+func (c *Cache) dbScan(ctx context.Context, prefix string, fn func(key string) bool) error {
+	s, err := c.scanDB()
+	if err != nil {
+		return err
+	}
+	return c.dbDo(ctx, opScan, 0, []string{prefix}, func(ctx context.Context) error {
+		return s.Scan(ctx, c.dbKey(prefix), func(key string) bool {
+			if k, ok := c.userKey(key); ok && !isTagKey(k) {
+				return fn(k)
+			}
+			return true
+		})
+	})
+}
+
+// 按前缀删除, 不限制超时
+func (c *Cache) dbDelPrefix(ctx context.Context, prefix string) error {
+	s, err := c.scanDB()
+	if err != nil {
+		return err
+	}
+	return c.dbDo(ctx, opDelPrefix, 0, []string{prefix}, func(ctx context.Context) error {
+		return s.DelPrefix(ctx, c.dbKey(prefix))
+	})
+}
+
+// 清空缓存数据库, 不限制超时
+func (c *Cache) dbClear(ctx context.Context) error {
+	s, err := c.scanDB()
+	if err != nil {
+		return err
+	}
+	return c.dbDo(ctx, opClear, 0, nil, func(ctx context.Context) error {
+		return s.Clear(ctx)
+	})
+}
@@ -2,18 +2,20 @@ package cache
 
 import (
 	"context"
-	"fmt"
+	"time"
 
 	"github.com/zly-app/zapp/filter"
+	"github.com/zly-app/zapp/pkg/utils"
 
 	"github.com/zly-app/cache/v2/core"
+	"github.com/zly-app/cache/v2/errs"
 )
 
 type setReq struct {
-	Key       string
-	Data      interface{}
-	opt       *options
-	ExpireSec int
+	Key    string
+	Data   interface{}
+	opt    *options
+	Expire time.Duration
 }
 
 func (c *Cache) Set(ctx context.Context, key string, data interface{}, opts ...core.Option) error {
@@ -22,15 +24,19 @@ func (c *Cache) Set(ctx context.Context, key string, data interface{}, opts ...c
 
 	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "Set")
 	r := &setReq{
-		Key:       key,
-		Data:      data,
-		opt:       opt,
-		ExpireSec: opt.ExpireSec,
+		Key:    key,
+		Data:   data,
+		opt:    opt,
+		Expire: opt.TTL(),
 	}
 	_, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
 		r := req.(*setReq)
-		bs, err := c.marshalQuery(r.Data, r.opt.Serializer, r.opt.Compactor)
+		c.setSpanAttr(ctx, c.traceKeyAttr(r.Key), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		c.setSpanAttr(ctx, r.opt.MakeTraceAttr()...)
+
+		bs, err := c.marshalQuery(r.Key, r.Data, r.opt.Serializer, r.opt.Compactor)
 		if err == nil {
+			c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrValueSize).Int(len(bs)))
 			err = c.set(ctx, key, bs, opt)
 		}
 		return nil, err
@@ -39,9 +45,31 @@ func (c *Cache) Set(ctx context.Context, key string, data interface{}, opts ...c
 }
 
 func (c *Cache) set(ctx context.Context, key string, bs []byte, opt *options) error {
-	err := c.cacheDB.Set(ctx, key, bs, opt.ExpireSec)
+	if opt.Expired() { // 已经过了过期时间, 不写入并删除旧数据
+		utils.Otel.CtxEvent(ctx, "ExpiredWriteSkip")
+		return c.del(ctx, key)
+	}
+
+	bs, ttl := c.wrapValue(bs, opt.TTL(), opt)
+	err := c.dbSet(ctx, key, bs, ttl, c.writeDeadline(opt))
+	if err == nil {
+		err = c.addTags(ctx, key, ttl, opt.Tags)
+	}
+	if err == nil && c.enableDependency { // 数据已更新, 删除依赖它的key
+		err = c.delDependents(ctx, key)
+	}
+	c.listener.Emit(opt.Listeners, func(l core.IListener) { l.OnSet(ctx, key, err) })
 	if err != nil {
-		return fmt.Errorf("写入缓存失败: %v", err)
+		c.listener.Emit(opt.Listeners, func(l core.IListener) { l.OnError(ctx, key, err) })
+		return &CacheFaultError{Key: key, Stage: errs.StageSet, Err: err}
 	}
 	return nil
 }
+
+// 写入的截止时间, 使用 WithExpireAt 并且截止时间没有超过最大存活时间时返回过期时间, 否则返回零值
+func (c *Cache) writeDeadline(opt *options) time.Time {
+	if opt.ExpireAt.IsZero() || (c.maxLifetime > 0 && time.Until(opt.ExpireAt) > c.maxLifetime) {
+		return time.Time{}
+	}
+	return opt.ExpireAt
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/zly-app/zapp/filter"
+	"github.com/zly-app/zapp/pkg/utils"
 
 	"github.com/zly-app/cache/v2/core"
 )
@@ -19,7 +20,7 @@ func (c *Cache) SingleFlightDo(ctx context.Context, key string, aPtr interface{}
 	r := &getReq{
 		Key:            key,
 		opt:            opt,
-		ExpireSec:      opt.ExpireSec,
+		Expire:         opt.TTL(),
 		ForceLoad:      opt.ForceLoad,
 		DontWriteCache: opt.DontWriteCache,
 	}
@@ -28,9 +29,13 @@ func (c *Cache) SingleFlightDo(ctx context.Context, key string, aPtr interface{}
 		r := req.(*getReq)
 		sp := rsp
 
+		c.setSpanAttr(ctx, c.traceKeyAttr(r.Key), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		c.setSpanAttr(ctx, r.opt.MakeTraceAttr()...)
+
 		comData, err := c.singleFlightDo(ctx, r.Key, r.opt)
 		if err == nil {
-			err = c.unmarshalQuery(comData, sp, r.opt.Serializer, r.opt.Compactor)
+			c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrValueSize).Int(len(comData)))
+			err = c.unmarshalQuery(r.Key, comData, sp, r.opt.Serializer, r.opt.Compactor)
 		}
 		return err
 	})
@@ -42,6 +47,6 @@ func (c *Cache) singleFlightDo(ctx context.Context, key string, opt *options) ([
 		return nil, errors.New("LoadFn is nil")
 	}
 
-	bs, err := c.sf.Do(ctx, key, c.load(opt))
+	bs, err := c.sfDo(ctx, key, opt)
 	return bs, err
 }
@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/zly-app/zapp/logger"
+	"go.uber.org/zap"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+// 从缓存数据库获取数据, 启用滑动过期时会重置有效期. 超过最大存活时间的数据视为未命中
+func (c *Cache) getWithSliding(ctx context.Context, key string, opt *options) (*cacheValue, error) {
+	if !opt.SlidingExpire {
+		bs, err := c.dbGet(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseValue(key, bs)
+		if err != nil {
+			return nil, err
+		}
+		deadline := v.hardExpire()
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return nil, ErrCacheMiss
+		}
+		return v, nil
+	}
+
+	ttl := opt.TTL()
+	bs, touched, err := c.dbGetAndTouch(ctx, key, ttl)
+	if err != nil {
+		return nil, err
+	}
+	v, err := parseValue(key, bs)
+	if err != nil {
+		return nil, err
+	}
+	deadline := v.hardExpire()
+
+	// 有效期不能超过剩余的存活时间
+	if !deadline.IsZero() {
+		remain := time.Until(deadline)
+		if remain <= 0 {
+			return nil, ErrCacheMiss
+		}
+		if ttl <= 0 || ttl > remain {
+			ttl = remain
+			touched = false
+		}
+	}
+
+	if !touched {
+		if !c.touchable { // 缓存数据库不支持修改有效期, 无法滑动过期
+			return v, nil
+		}
+		err = c.dbTouch(ctx, key, ttl)
+		if err != nil {
+			if err != ErrCacheMiss && err != ErrCircuitOpen {
+				logger.Log.Warn("滑动过期重置有效期失败", zap.String("key", key), zap.Error(err))
+			}
+			return v, nil
+		}
+	}
+	if c.tagIndex != nil {
+		c.tagIndex.Touch(key, ttl)
+	}
+	return v, nil
+}
+
+// 获取数据并重置有效期, 缓存数据库不支持时只获取数据, 返回是否已重置有效期
+func (c *Cache) dbGetAndTouch(ctx context.Context, key string, ttl time.Duration) ([]byte, bool, error) {
+	s, ok := c.cacheDB.(core.ISlidingCacheDB)
+	if _, supported := unwrapCacheDB(c.cacheDB).(core.ISlidingCacheDB); !ok || !supported {
+		bs, err := c.dbGet(ctx, key)
+		return bs, false, err
+	}
+
+	var bs []byte
+	err := c.dbDo(ctx, opGet, c.getTimeout, []string{key}, func(ctx context.Context) (err error) {
+		bs, err = s.GetAndTouch(ctx, c.dbKey(key), ttl)
+		return err
+	})
+	switch err {
+	case nil:
+		c.metrics.Hit(opGet)
+	case ErrCacheMiss:
+		c.metrics.Miss(opGet)
+	}
+	return bs, err == nil, err
+}
@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zly-app/zapp/logger"
+	"github.com/zly-app/zapp/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// 加载期间key被删除, 加载的数据不应写入缓存
+var errStaleWrite = errors.New("stale write")
+
+type keyLease struct {
+	version uint64
+	refs    int // 正在加载的数量
+}
+
+// 本地版本表, 只记录正在加载的key, 用于不支持版本控制的缓存数据库
+type leaseMap struct {
+	mx     sync.Mutex
+	leases map[string]*keyLease
+}
+
+func newLeaseMap() *leaseMap {
+	return &leaseMap{leases: make(map[string]*keyLease)}
+}
+
+// 开始加载, 返回key当前的版本
+func (m *leaseMap) Acquire(key string) uint64 {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	l, ok := m.leases[key]
+	if !ok {
+		l = &keyLease{}
+		m.leases[key] = l
+	}
+	l.refs++
+	return l.version
+}
+
+// 检查key的版本是否已改变
+func (m *leaseMap) Changed(key string, version uint64) bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	l, ok := m.leases[key]
+	return !ok || l.version != version
+}
+
+// 结束加载
+func (m *leaseMap) Release(key string) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	l, ok := m.leases[key]
+	if !ok {
+		return
+	}
+	l.refs--
+	if l.refs <= 0 {
+		delete(m.leases, key)
+	}
+}
+
+// 更新正在加载的key的版本
+func (m *leaseMap) Invalidate(keys ...string) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	for _, key := range keys {
+		if l, ok := m.leases[key]; ok {
+			l.version++
+		}
+	}
+}
+
+// 更新正在加载的前缀为prefix的key的版本
+func (m *leaseMap) InvalidatePrefix(prefix string) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	for key, l := range m.leases {
+		if strings.HasPrefix(key, prefix) {
+			l.version++
+		}
+	}
+}
+
+// 加载前获取的写入令牌
+type writeToken struct {
+	key     string
+	version string // 缓存数据库中的版本
+	local   uint64 // 本地版本表中的版本
+	invalid bool   // 获取版本失败, 不写入缓存
+}
+
+// 获取写入令牌, 未启用时返回nil. 返回的令牌不为nil时必须调用 releaseWriteToken
+func (c *Cache) acquireWriteToken(ctx context.Context, key string) *writeToken {
+	if !c.staleWriteProtect {
+		return nil
+	}
+
+	t := &writeToken{key: key}
+	if c.leases != nil {
+		t.local = c.leases.Acquire(key)
+		return t
+	}
+
+	version, err := c.dbGetVersion(ctx, key)
+	if err != nil {
+		t.invalid = true
+		if err != ErrCircuitOpen {
+			logger.Log.Error("获取缓存版本失败", zap.String("key", key), zap.Error(err))
+		}
+	}
+	t.version = version
+	return t
+}
+
+func (c *Cache) releaseWriteToken(t *writeToken) {
+	if t != nil && c.leases != nil {
+		c.leases.Release(t.key)
+	}
+}
+
+// 写入加载的数据, 加载期间key被删除时不写入并返回 errStaleWrite
+func (c *Cache) setWithToken(ctx context.Context, key string, t *writeToken, bs []byte, ttl time.Duration, expireAt time.Time) error {
+	if t == nil {
+		return c.dbSet(ctx, key, bs, ttl, expireAt)
+	}
+	if t.invalid {
+		utils.Otel.CtxEvent(ctx, "StaleWriteSkip")
+		return errStaleWrite
+	}
+
+	if c.leases == nil {
+		ok, err := c.dbSetIfVersion(ctx, key, bs, ttl, expireAt, t.version)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			utils.Otel.CtxEvent(ctx, "StaleWriteSkip")
+			return errStaleWrite
+		}
+		return nil
+	}
+
+	err := c.dbSet(ctx, key, bs, ttl, expireAt)
+	if err != nil || !c.leases.Changed(key, t.local) {
+		return err
+	}
+	// 写入前后key被删除了, 删除刚写入的旧数据
+	utils.Otel.CtxEvent(ctx, "StaleWriteSkip")
+	if err = c.dbDel(ctx, key); err != nil {
+		logger.Log.Error("删除旧数据失败", zap.String("key", key), zap.Error(err))
+	}
+	return errStaleWrite
+}
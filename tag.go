@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zly-app/zapp/filter"
+	"github.com/zly-app/zapp/logger"
+	"github.com/zly-app/zapp/pkg/utils"
+	"go.uber.org/zap"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+const (
+	tagIndexGCInterval = time.Minute // 本地标签索引清理过期key的间隔
+	delByTagBatchSize  = 1000        // DelByTag 每次删除的key数量
+)
+
+// 标签在缓存数据库中的key的前缀, 标签的key为 {KeyPrefix}:v{Version}:__tag:{tag}
+const tagKeyPrefix = "__tag:"
+
+// 是否为标签的key
+func isTagKey(key string) bool {
+	return strings.HasPrefix(key, tagKeyPrefix)
+}
+
+/*
+本地标签索引, 用于不支持标签的缓存数据库.
+
+	与 redis 一样标签只会增加, 重新写入时key原有的标签不会被移除. 不再属于某个标签的key会一直留在标签中,
+	直到过期, 被删除或者通过 DelByTag 删除时才会被清理
+*/
+type tagIndex struct {
+	mx      sync.Mutex
+	tags    map[string]map[string]time.Time // 标签 -> key -> 过期时间, 零值表示永久
+	keyTags map[string]map[string]struct{}  // key -> 标签
+	nextGC  time.Time
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{
+		tags:    make(map[string]map[string]time.Time),
+		keyTags: make(map[string]map[string]struct{}),
+	}
+}
+
+// 将key加入标签, 已在标签中时更新过期时间. 不会移除key原有的标签
+func (x *tagIndex) Add(key string, ttl time.Duration, tags ...string) {
+	var expireAt time.Time
+	now := time.Now()
+	if ttl > 0 {
+		expireAt = now.Add(ttl)
+	}
+
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	if len(tags) > 0 {
+		keyTags, ok := x.keyTags[key]
+		if !ok {
+			keyTags = make(map[string]struct{}, len(tags))
+			x.keyTags[key] = keyTags
+		}
+		for _, tag := range tags {
+			keys, ok := x.tags[tag]
+			if !ok {
+				keys = make(map[string]time.Time)
+				x.tags[tag] = keys
+			}
+			keys[key] = expireAt
+			keyTags[tag] = struct{}{}
+		}
+	}
+
+	// 定期清理过期的key
+	if now.After(x.nextGC) {
+		for tag, keys := range x.tags {
+			for k, t := range keys {
+				if !t.IsZero() && now.After(t) {
+					x.remove(tag, k)
+				}
+			}
+		}
+		x.nextGC = now.Add(tagIndexGCInterval)
+	}
+}
+
+// 获取标签下未过期的key
+func (x *tagIndex) Members(tag string) []string {
+	now := time.Now()
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	keys := make([]string, 0, len(x.tags[tag]))
+	for k, t := range x.tags[tag] {
+		if t.IsZero() || now.Before(t) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// 从标签中移除key, 不影响key的其它标签
+func (x *tagIndex) Remove(tag string, keys ...string) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	for _, key := range keys {
+		x.remove(tag, key)
+	}
+}
+
+// 更新key在标签中的过期时间
+func (x *tagIndex) Touch(key string, ttl time.Duration) {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	for tag := range x.keyTags[key] {
+		x.tags[tag][key] = expireAt
+	}
+}
+
+// 移除前缀为prefix的key的所有标签
+func (x *tagIndex) RemovePrefix(prefix string) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	for key := range x.keyTags {
+		if strings.HasPrefix(key, prefix) {
+			x.removeKey(key)
+		}
+	}
+}
+
+// 移除key的所有标签
+func (x *tagIndex) RemoveKeys(keys ...string) {
+	x.mx.Lock()
+	defer x.mx.Unlock()
+
+	for _, key := range keys {
+		x.removeKey(key)
+	}
+}
+
+func (x *tagIndex) removeKey(key string) {
+	for tag := range x.keyTags[key] {
+		x.remove(tag, key)
+	}
+}
+
+func (x *tagIndex) remove(tag, key string) {
+	if keys, ok := x.tags[tag]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(x.tags, tag)
+		}
+	}
+	if keyTags, ok := x.keyTags[key]; ok {
+		delete(keyTags, tag)
+		if len(keyTags) == 0 {
+			delete(x.keyTags, key)
+		}
+	}
+}
+
+/*
+写入缓存成功后记录key的标签, 记录失败时会删除key, 避免留下无法通过标签删除的数据.
+
+	没有标签时直接返回, 不会移除key原有的标签
+*/
+func (c *Cache) addTags(ctx context.Context, key string, ttl time.Duration, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	if c.tagIndex != nil {
+		c.tagIndex.Add(key, ttl, tags...)
+		return nil
+	}
+
+	err := c.dbAddTags(ctx, key, ttl, tags)
+	if err == nil {
+		return nil
+	}
+	if delErr := c.dbDel(ctx, key); delErr != nil {
+		logger.Log.Error("记录标签失败后删除缓存失败", zap.String("key", key), zap.Error(delErr))
+	}
+	return fmt.Errorf("记录标签失败: %w", err)
+}
+
+type delByTagReq struct {
+	Tag string
+}
+
+func (c *Cache) DelByTag(ctx context.Context, tag string) error {
+	ctx, chain := filter.GetClientFilter(ctx, string(defComponentType), c.cacheName, "DelByTag")
+	r := &delByTagReq{Tag: tag}
+	_, err := chain.Handle(ctx, r, func(ctx context.Context, req interface{}) (interface{}, error) {
+		r := req.(*delByTagReq)
+		c.setSpanAttr(ctx, utils.OtelSpanKey(traceAttrTag).String(r.Tag), utils.OtelSpanKey(traceAttrBackend).String(c.backend))
+		err := c.delByTag(ctx, r.Tag)
+		return nil, err
+	})
+	return err
+}
+
+func (c *Cache) delByTag(ctx context.Context, tag string) error {
+	var keys []string
+	if c.tagIndex != nil {
+		keys = c.tagIndex.Members(tag)
+	} else {
+		var err error
+		keys, err = c.dbTagMembers(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("获取标签成员失败: %w", err)
+		}
+	}
+
+	// 删除成功后才从标签中移除, 删除期间新加入标签的key不受影响
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > delByTagBatchSize {
+			n = delByTagBatchSize
+		}
+		batch := keys[:n]
+		keys = keys[n:]
+
+		if err := c.del(ctx, batch...); err != nil {
+			return err
+		}
+		if c.tagIndex != nil {
+			continue // del 已经从本地索引中移除
+		}
+		if err := c.dbRemoveFromTag(ctx, tag, batch); err != nil {
+			return fmt.Errorf("从标签中移除key失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// 添加标签, 缓存数据库必须实现 core.ITaggedCacheDB
+func (c *Cache) dbAddTags(ctx context.Context, key string, ttl time.Duration, tags []string) error {
+	return c.dbDo(ctx, opAddTags, c.setTimeout, []string{key}, func(ctx context.Context) error {
+		return c.cacheDB.(core.ITaggedCacheDB).AddTags(ctx, c.dbKey(key), ttl, c.tagDBKeys(tags)...)
+	})
+}
+
+// 获取标签成员, 缓存数据库必须实现 core.ITaggedCacheDB
+func (c *Cache) dbTagMembers(ctx context.Context, tag string) ([]string, error) {
+	var keys []string
+	err := c.dbDo(ctx, opTagMembers, c.getTimeout, nil, func(ctx context.Context) (err error) {
+		keys, err = c.cacheDB.(core.ITaggedCacheDB).TagMembers(ctx, c.dbKey(tagKeyPrefix+tag))
+		return err
+	})
+	return c.userKeys(keys), err
+}
+
+// 从标签中移除key, 缓存数据库必须实现 core.ITaggedCacheDB
+func (c *Cache) dbRemoveFromTag(ctx context.Context, tag string, keys []string) error {
+	return c.dbDo(ctx, opRemoveFromTag, c.delTimeout, nil, func(ctx context.Context) error {
+		return c.cacheDB.(core.ITaggedCacheDB).RemoveFromTag(ctx, c.dbKey(tagKeyPrefix+tag), c.dbKeys(keys)...)
+	})
+}
+
+// 获取标签在缓存数据库中的key
+func (c *Cache) tagDBKeys(tags []string) []string {
+	ks := make([]string, len(tags))
+	for i, tag := range tags {
+		ks[i] = c.dbKey(tagKeyPrefix + tag)
+	}
+	return ks
+}
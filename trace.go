@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+
+	"github.com/zly-app/zapp/pkg/utils"
+)
+
+const (
+	traceAttrKey        = "cache.key"
+	traceAttrBackend    = "cache.backend"
+	traceAttrResult     = "cache.result"
+	traceAttrValueSize  = "cache.value_size"
+	traceAttrSerializer = "cache.serializer"
+	traceAttrCompactor  = "cache.compactor"
+	traceAttrExpireMs   = "cache.expire_ms"
+	traceAttrForceLoad  = "cache.force_load"
+	traceAttrDelayMs    = "cache.delay_ms"
+	traceAttrTag        = "cache.tag"
+)
+
+// 缓存数据库查询结果
+const (
+	traceResultHit    = "hit"    // 命中
+	traceResultMiss   = "miss"   // 未命中
+	traceResultFault  = "fault"  // 缓存数据库故障
+	traceResultBypass = "bypass" // 熔断器已打开, 跳过缓存数据库
+	traceResultStale  = "stale"  // 加载期间key被删除, 加载的数据没有写入缓存, 记录在加载的span上
+)
+
+// 获取链路追踪中记录的key
+func (c *Cache) traceKey(key string) string {
+	if !c.traceHashKey {
+		return key
+	}
+	f := fnv.New64a()
+	_, _ = f.Write([]byte(key))
+	return strconv.FormatUint(f.Sum64(), 16)
+}
+
+// 生成key相关的属性
+func (c *Cache) traceKeyAttr(keys ...string) utils.OtelSpanKV {
+	if len(keys) == 1 {
+		return utils.OtelSpanKey(traceAttrKey).String(c.traceKey(keys[0]))
+	}
+	ks := make([]string, len(keys))
+	for i, key := range keys {
+		ks[i] = c.traceKey(key)
+	}
+	return utils.OtelSpanKey(traceAttrKey).StringSlice(ks)
+}
+
+// 为当前span设置属性
+func (c *Cache) setSpanAttr(ctx context.Context, attributes ...utils.OtelSpanKV) {
+	utils.Otel.SetSpanAttributes(utils.Otel.GetSpan(ctx), attributes...)
+}
+
+// 开始一个子span
+func (c *Cache) startSpan(ctx context.Context, name string, keys ...string) context.Context {
+	return utils.Otel.CtxStart(ctx, name,
+		c.traceKeyAttr(keys...),
+		utils.OtelSpanKey(traceAttrBackend).String(c.backend),
+	)
+}
+
+// 结束一个子span, 未命中不视为错误
+func (c *Cache) endSpan(ctx context.Context, err error) {
+	if err != nil && err != ErrCacheMiss {
+		utils.Otel.CtxErrEvent(ctx, "Result", err)
+	}
+	utils.Otel.CtxEnd(ctx)
+}
@@ -0,0 +1,123 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/zly-app/zapp/logger"
+	"github.com/zly-app/zapp/pkg/utils"
+	"go.uber.org/zap"
+
+	"github.com/zly-app/cache/v2/core"
+	"github.com/zly-app/cache/v2/errs"
+)
+
+// 将缓存数据升级到当前的数据结构版本, 返回升级后的数据. 不需要升级时原样返回
+func (c *Cache) upcast(ctx context.Context, key string, v *cacheValue, opt *options) (*cacheValue, error) {
+	if c.schemaVersion == 0 {
+		return v, nil
+	}
+	env := v.env
+	if env == nil {
+		env = &envelope{}
+	}
+	if env.SchemaVersion >= c.schemaVersion || len(v.payload) == 0 || env.Flags&envelopeFlagNil != 0 {
+		return v, nil
+	}
+
+	serializer, compactor := env.codec(opt.Serializer, opt.Compactor)
+	data, err := compactor.UnCompressBytes(v.payload)
+	if err != nil {
+		return nil, &CodecError{Key: key, Stage: errs.StageUncompress, Err: err}
+	}
+	for ver := int(env.SchemaVersion); ver < int(c.schemaVersion); ver++ {
+		fn, ok := c.upcasters[ver]
+		if !ok {
+			return nil, &CodecError{Key: key, Stage: errs.StageUpcast, Err: fmt.Errorf("缺少版本%d的升级函数", ver)}
+		}
+		err = utils.Recover.WrapCall(func() error {
+			data, err = fn(data, serializer)
+			return err
+		})
+		if err != nil {
+			return nil, &CodecError{Key: key, Stage: errs.StageUpcast, Err: fmt.Errorf("版本%d升级失败: %w", ver, err)}
+		}
+	}
+	comData, err := compactor.CompressBytes(data)
+	if err != nil {
+		return nil, &CodecError{Key: key, Stage: errs.StageCompress, Err: err}
+	}
+	utils.Otel.CtxEvent(ctx, "Upcast")
+
+	newEnv := c.upcastEnvelope(env, opt)
+	newBs := encodeEnvelope(newEnv, comData)
+	if c.upcastWriteBack && !opt.DontWriteCache {
+		c.writeBackUpcast(ctx, key, v.raw, newBs, newEnv)
+	}
+	return &cacheValue{raw: newBs, env: newEnv, payload: newBs[envelopeHeaderSize:]}, nil
+}
+
+// 生成升级后的数据信封, 保留原数据的编解码器, 写入时间和过期时间. 没有信封的旧数据使用当前时间作为写入时间
+func (c *Cache) upcastEnvelope(env *envelope, opt *options) *envelope {
+	newEnv := *env
+	newEnv.Version = envelopeVersion
+	newEnv.SchemaVersion = c.schemaVersion
+	if newEnv.SerializerID == 0 {
+		newEnv.SerializerID = serializerID(opt.SerializerName, opt.Serializer)
+	}
+	if newEnv.CompactorID == 0 {
+		newEnv.CompactorID = compactorID(opt.CompactorName, opt.Compactor)
+	}
+	if newEnv.CreatedAt.IsZero() {
+		newEnv.CreatedAt = time.Now()
+	}
+	if newEnv.HardExpire.IsZero() && c.maxLifetime > 0 {
+		newEnv.HardExpire = time.Now().Add(c.maxLifetime)
+	}
+	return &newEnv
+}
+
+/*
+将升级后的数据写回缓存, 只在缓存中的数据仍然是升级前的数据时写入, 避免覆盖期间写入或删除的数据.
+
+	有效期使用缓存数据库中的剩余有效期, 并且不超过最大存活时间. 写回失败只记录日志
+*/
+func (c *Cache) writeBackUpcast(ctx context.Context, key string, old, bs []byte, env *envelope) {
+	cas, ok := c.cacheDB.(core.ICompareAndSetCacheDB)
+	if _, supported := unwrapCacheDB(c.cacheDB).(core.ICompareAndSetCacheDB); !ok || !supported {
+		return
+	}
+	ttl, err := c.dbTTL(ctx, key)
+	if err != nil {
+		if err != ErrCacheMiss && err != ErrCircuitOpen {
+			logger.Log.Warn("获取升级前数据的有效期失败", zap.String("key", key), zap.Error(err))
+		}
+		return
+	}
+	if ttl == NoExpire {
+		ttl = 0
+	} else if ttl <= 0 {
+		return
+	}
+	if !env.HardExpire.IsZero() {
+		remain := time.Until(env.HardExpire)
+		if remain <= 0 {
+			return
+		}
+		if ttl <= 0 || ttl > remain {
+			ttl = remain
+		}
+	}
+
+	err = c.dbDo(ctx, opSet, c.setTimeout, []string{key}, func(ctx context.Context) error {
+		written, err := cas.CompareAndSet(ctx, c.dbKey(key), old, bs, ttl)
+		if err == nil && !written {
+			utils.Otel.CtxEvent(ctx, "UpcastWriteBackSkip")
+		}
+		return err
+	})
+	if err != nil && err != ErrCircuitOpen {
+		logger.Log.Warn("写回升级后的数据失败", zap.String("key", key), zap.Error(err))
+	}
+}
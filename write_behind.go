@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/zly-app/zapp/logger"
+	"github.com/zly-app/zapp/pkg/utils"
+	"go.uber.org/zap"
+
+	"github.com/zly-app/cache/v2/core"
+)
+
+// 等待写入的数据
+type pendingWrite struct {
+	data      interface{}
+	saveFn    core.SaveFn
+	listeners []core.IListener
+}
+
+// 延迟写入, 同一个key的写入会被合并且按顺序执行
+type writeBehind struct {
+	cache      *Cache
+	queueSize  int
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+
+	mx      sync.Mutex
+	closed  bool
+	pending map[string]*pendingWrite
+	queues  []chan string
+	wg      sync.WaitGroup
+}
+
+func newWriteBehind(cache *Cache, conf *Config) *writeBehind {
+	w := &writeBehind{
+		cache:      cache,
+		queueSize:  conf.WriteBehind.QueueSize,
+		attempts:   conf.WriteBehind.Attempts,
+		backoff:    time.Duration(conf.WriteBehind.BackoffMs) * time.Millisecond,
+		maxBackoff: time.Duration(conf.WriteBehind.MaxBackoffMs) * time.Millisecond,
+		pending:    make(map[string]*pendingWrite),
+		queues:     make([]chan string, conf.WriteBehind.Workers),
+	}
+	for i := range w.queues {
+		// 每个key在pending中最多只有一个, 所以队列不会超过 queueSize
+		w.queues[i] = make(chan string, conf.WriteBehind.QueueSize)
+		w.wg.Add(1)
+		go w.loop(w.queues[i])
+	}
+	return w
+}
+
+// 放入队列, 如果该key已经在队列中则只更新数据. data 必须是调用方不会再修改的数据
+func (w *writeBehind) Push(key string, data interface{}, saveFn core.SaveFn, listeners []core.IListener) error {
+	w.mx.Lock()
+	defer w.mx.Unlock()
+
+	if w.closed {
+		return ErrCacheClosed
+	}
+	if p, ok := w.pending[key]; ok {
+		p.data, p.saveFn, p.listeners = data, saveFn, listeners
+		return nil
+	}
+	if len(w.pending) >= w.queueSize {
+		return ErrWriteBehindQueueFull
+	}
+	w.pending[key] = &pendingWrite{data: data, saveFn: saveFn, listeners: listeners}
+
+	f := fnv.New32a()
+	_, _ = f.Write([]byte(key))
+	w.queues[f.Sum32()%uint32(len(w.queues))] <- key
+	return nil
+}
+
+func (w *writeBehind) loop(queue chan string) {
+	defer w.wg.Done()
+	for key := range queue {
+		w.mx.Lock()
+		p := w.pending[key]
+		delete(w.pending, key)
+		w.mx.Unlock()
+
+		if p != nil {
+			w.save(key, p)
+		}
+	}
+}
+
+func (w *writeBehind) save(key string, p *pendingWrite) {
+	ctx := context.Background()
+	backoff := w.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = utils.Recover.WrapCall(func() error {
+			return p.saveFn(ctx, key, p.data)
+		})
+		if err == nil || attempt >= w.attempts {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > w.maxBackoff {
+			backoff = w.maxBackoff
+		}
+		w.cache.metrics.Retry(opSave)
+	}
+	if err == nil {
+		return
+	}
+
+	w.cache.metrics.Err(opSave)
+	logger.Log.Error("延迟写入失败", zap.String("cacheName", w.cache.cacheName), zap.String("key", key), zap.Error(err))
+	w.cache.listener.Emit(p.listeners, func(l core.IListener) { l.OnError(ctx, key, err) })
+}
+
+// 关闭, 会等待队列中的数据写入完毕
+func (w *writeBehind) Close() {
+	w.mx.Lock()
+	if w.closed {
+		w.mx.Unlock()
+		return
+	}
+	w.closed = true
+	for _, queue := range w.queues {
+		close(queue)
+	}
+	w.mx.Unlock()
+
+	w.wg.Wait()
+}
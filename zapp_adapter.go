@@ -52,12 +52,12 @@ func (c *cacheCreatorAdapter) makeCache(name string) (conn.IInstance, error) {
 	conf := NewConfig()
 	err := zapp.App().GetConfig().ParseComponentConfig(defComponentType, name, conf, true)
 	if err != nil {
-		return nil, fmt.Errorf("cache配置错误: %v", err)
+		return nil, fmt.Errorf("cache配置错误: %w", err)
 	}
 
 	cache, err := NewCache(name, conf)
 	if err != nil {
-		return nil, fmt.Errorf("cache创建失败: %v", err)
+		return nil, fmt.Errorf("cache创建失败: %w", err)
 	}
 	return &instance{cache: cache}, nil
 }